@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const importPath = "github.com/hysios/binary"
+
+// kind is how a field is encoded by the generated code.
+type kind int
+
+const (
+	kindOther kind = iota
+	kindInt
+	kindUint
+	kindFloat
+	kindBool
+	kindString
+	kindBytes
+	kindTime
+	kindDuration
+	kindStruct
+	kindStructPtr   // pointer to a generated struct
+	kindStructSlice // slice of a generated struct
+	kindStructArray // array of a generated struct
+)
+
+// basicTypes holds the kind and tag of the predeclared scalar types.
+var basicTypes = map[string]struct {
+	kind kind
+	tag  string
+}{
+	"int":     {kindInt, "Int"},
+	"int8":    {kindInt, "Int8"},
+	"int16":   {kindInt, "Int16"},
+	"int32":   {kindInt, "Int32"},
+	"rune":    {kindInt, "Int32"},
+	"int64":   {kindInt, "Int64"},
+	"uint":    {kindUint, "Uint"},
+	"uint8":   {kindUint, "Uint8"},
+	"byte":    {kindUint, "Uint8"},
+	"uint16":  {kindUint, "Uint16"},
+	"uint32":  {kindUint, "Uint32"},
+	"uint64":  {kindUint, "Uint64"},
+	"float32": {kindFloat, "Float32"},
+	"float64": {kindFloat, "Float64"},
+	"bool":    {kindBool, "Bool"},
+	"string":  {kindString, "String"},
+}
+
+// typeDecl is a type declared by the package.
+type typeDecl struct {
+	spec    *ast.TypeSpec
+	imports map[string]string // import paths by name in the declaring file
+	methods map[string]bool   // methods with a value receiver
+}
+
+// field is an encoded field of a generated struct.
+type field struct {
+	name     string // name on the wire
+	expr     string // Go expression of the field value
+	goType   string // Go type of scalar fields
+	kind     kind
+	tag      string // binary.Type of scalar fields
+	elem     string // generated struct type of pointers, slices and arrays
+	array    string // Go type of arrays
+	nonEmpty string // condition the field is not empty under, "" if it always is
+	omit     bool
+}
+
+type generator struct {
+	pkg     string
+	types   map[string]*typeDecl
+	gen     map[string]bool
+	imports map[string]bool
+	buf     bytes.Buffer
+}
+
+// generate returns the source of the methods of the struct types named by
+// types in the package in dir.
+func generate(dir string, types []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	g := &generator{
+		types:   make(map[string]*typeDecl),
+		gen:     make(map[string]bool),
+		imports: make(map[string]bool),
+	}
+	for _, pkg := range pkgs {
+		g.pkg = pkg.Name
+		g.collect(pkg)
+	}
+
+	for _, name := range types {
+		d, ok := g.types[name]
+		if !ok {
+			return nil, fmt.Errorf("type %s not found in %s", name, dir)
+		}
+		if _, ok := d.spec.Type.(*ast.StructType); !ok || d.spec.Assign != 0 || d.spec.TypeParams != nil {
+			return nil, fmt.Errorf("type %s is not a struct type", name)
+		}
+		g.gen[name] = true
+	}
+	for _, name := range types {
+		if err := g.generateType(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by \"binarygen -type %s\"; DO NOT EDIT.\n\n", strings.Join(types, ","))
+	fmt.Fprintf(&src, "package %s\n\n", g.pkg)
+	fmt.Fprintf(&src, "import (\n")
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		fmt.Fprintf(&src, "\t%q\n", path)
+	}
+	fmt.Fprintf(&src, "\n\t%q\n)\n", importPath)
+	src.Write(g.buf.Bytes())
+
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid generated source: %v", err)
+	}
+	return out, nil
+}
+
+// collect records the type declarations and value receiver methods of pkg.
+func (g *generator) collect(pkg *ast.Package) {
+	methods := make(map[string]map[string]bool)
+	for _, file := range pkg.Files {
+		imports := make(map[string]string)
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = path
+		}
+
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					ts := spec.(*ast.TypeSpec)
+					g.types[ts.Name.Name] = &typeDecl{spec: ts, imports: imports}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 {
+					continue
+				}
+				if id, ok := decl.Recv.List[0].Type.(*ast.Ident); ok {
+					if methods[id.Name] == nil {
+						methods[id.Name] = make(map[string]bool)
+					}
+					methods[id.Name][decl.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	for name, d := range g.types {
+		d.methods = methods[name]
+	}
+}
+
+// fields returns the encoded fields of the struct type name, in the order
+// typeFields of the binary package returns them, and the expression of its
+// binary.UnknownFields field if it has one.
+func (g *generator) fields(name string) ([]field, string, error) {
+	var (
+		d       = g.types[name]
+		st      = d.spec.Type.(*ast.StructType)
+		fields  []field
+		unknown string
+	)
+	for _, f := range st.Fields.List {
+		names := make([]string, 0, len(f.Names))
+		for _, id := range f.Names {
+			names = append(names, id.Name)
+		}
+		if len(names) == 0 {
+			names = append(names, embeddedName(f.Type))
+		}
+
+		if isUnknownFields(f.Type, d.imports) {
+			for _, n := range names {
+				if ast.IsExported(n) && unknown == "" {
+					unknown = "x." + n
+				}
+			}
+			continue
+		}
+
+		var tag string
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("binary")
+		}
+		if tag == "-" {
+			continue
+		}
+		wire, opts := tag, ""
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			wire, opts = tag[:idx], tag[idx+1:]
+		}
+
+		for _, n := range names {
+			if !ast.IsExported(n) {
+				continue
+			}
+
+			fd := field{name: wire, expr: "x." + n, omit: hasOption(opts, "omitempty")}
+			if fd.name == "" {
+				fd.name = n
+			}
+			fd.kind, fd.tag, fd.elem = g.classify(f.Type, d.imports)
+			if fd.kind == kindStructArray {
+				var buf bytes.Buffer
+				format.Node(&buf, token.NewFileSet(), f.Type)
+				fd.array = buf.String()
+			}
+			if id, ok := f.Type.(*ast.Ident); ok {
+				fd.goType = id.Name
+			}
+			if fd.omit {
+				cond, err := g.nonEmpty(f.Type, fd.expr, d.imports, 0)
+				if err != nil {
+					return nil, "", fmt.Errorf("field %s of %s: %v", n, name, err)
+				}
+				fd.nonEmpty = cond
+			}
+			fields = append(fields, fd)
+		}
+	}
+	return fields, unknown, nil
+}
+
+func isUnknownFields(expr ast.Expr, imports map[string]string) bool {
+	e, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := e.X.(*ast.Ident)
+	return ok && imports[x.Name] == importPath && e.Sel.Name == "UnknownFields"
+}
+
+func embeddedName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+func hasOption(opts, name string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the kind of fields of type expr, their binary.Type and
+// the generated struct type of their elements.
+func (g *generator) classify(expr ast.Expr, imports map[string]string) (kind, string, string) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if _, local := g.types[e.Name]; !local {
+			if b, ok := basicTypes[e.Name]; ok {
+				return b.kind, b.tag, ""
+			}
+		} else if g.gen[e.Name] {
+			return kindStruct, "", e.Name
+		}
+	case *ast.StarExpr:
+		if id, ok := e.X.(*ast.Ident); ok && g.gen[id.Name] {
+			return kindStructPtr, "", id.Name
+		}
+	case *ast.ArrayType:
+		id, ok := e.Elt.(*ast.Ident)
+		switch {
+		case !ok:
+		case e.Len == nil && g.types[id.Name] == nil && (id.Name == "byte" || id.Name == "uint8"):
+			return kindBytes, "", ""
+		case e.Len == nil && g.gen[id.Name]:
+			return kindStructSlice, "", id.Name
+		case g.gen[id.Name]:
+			return kindStructArray, "", id.Name
+		}
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok && imports[x.Name] == "time" {
+			switch e.Sel.Name {
+			case "Time":
+				return kindTime, "Timestamp", ""
+			case "Duration":
+				return kindDuration, "Duration", ""
+			}
+		}
+	}
+	return kindOther, "", ""
+}
+
+// nonEmpty returns the condition under which the value x of type expr is
+// not empty for omitempty, or "" when it is never empty.
+func (g *generator) nonEmpty(expr ast.Expr, x string, imports map[string]string, depth int) (string, error) {
+	if depth > 16 {
+		return "", fmt.Errorf("type cycle")
+	}
+
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return g.nonEmpty(e.X, x, imports, depth+1)
+	case *ast.Ident:
+		if d, ok := g.types[e.Name]; ok {
+			if _, ok := d.spec.Type.(*ast.StructType); ok && d.spec.Assign == 0 {
+				if d.methods["IsZero"] {
+					return "!" + x + ".IsZero()", nil
+				}
+				return "", nil
+			}
+			return g.nonEmpty(d.spec.Type, x, d.imports, depth+1)
+		}
+
+		switch e.Name {
+		case "string":
+			return x + ` != ""`, nil
+		case "bool":
+			return x, nil
+		case "error", "any":
+			return x + " != nil", nil
+		case "complex64", "complex128":
+			return "", nil
+		case "uintptr":
+			return x + " != 0", nil
+		}
+		if _, ok := basicTypes[e.Name]; ok {
+			return x + " != 0", nil
+		}
+	case *ast.StarExpr, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+		return x + " != nil", nil
+	case *ast.ArrayType, *ast.MapType:
+		return "len(" + x + ") != 0", nil
+	case *ast.StructType:
+		return "", nil
+	case *ast.SelectorExpr:
+		if id, ok := e.X.(*ast.Ident); ok && imports[id.Name] == "time" {
+			switch e.Sel.Name {
+			case "Time":
+				return "!" + x + ".IsZero()", nil
+			case "Duration":
+				return x + " != 0", nil
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return "", fmt.Errorf("cannot tell when a %s is empty for omitempty", buf.String())
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) generateType(name string) error {
+	fields, unknown, err := g.fields(name)
+	if err != nil {
+		return err
+	}
+
+	g.printf("\n// MarshalBinaryFormat implements binary.Marshaler.\n")
+	g.printf("func (x %s) MarshalBinaryFormat() ([]byte, error) {\n", name)
+	g.printf("return binary.AppendEncode(nil, &x)\n")
+	g.printf("}\n")
+
+	g.printf("\n// UnmarshalBinaryFormat implements binary.Unmarshaler.\n")
+	g.printf("func (x *%s) UnmarshalBinaryFormat(b []byte) error {\n", name)
+	g.printf("_, err := binary.Decode(b, x)\n")
+	g.printf("return err\n")
+	g.printf("}\n")
+
+	g.generateAppend(name, fields, unknown)
+	g.generateDecode(name, fields, unknown)
+	return nil
+}
+
+func (g *generator) generateAppend(name string, fields []field, unknown string) {
+	g.printf("\n// AppendBinaryFormat implements binary.AppendMarshaler.\n")
+	g.printf("func (x %s) AppendBinaryFormat(enc *binary.Encoder, b []byte) ([]byte, error) {\n", name)
+
+	count := 0
+	for _, f := range fields {
+		if !f.omit || f.nonEmpty == "" {
+			count++
+		}
+	}
+	g.printf("n := %d\n", count)
+	for _, f := range fields {
+		if f.omit && f.nonEmpty != "" {
+			g.printf("if %s {\nn++\n}\n", f.nonEmpty)
+		}
+	}
+	if unknown != "" {
+		g.printf("u, err := enc.CountUnknownFields(%s)\n", unknown)
+		g.printf("if err != nil {\nreturn nil, err\n}\n")
+		g.printf("if b, err = enc.AppendStructHeader(b, n+u); err != nil {\nreturn nil, err\n}\n")
+	} else {
+		g.printf("b, err := enc.AppendStructHeader(b, n)\n")
+		g.printf("if err != nil {\nreturn nil, err\n}\n")
+	}
+
+	for _, f := range fields {
+		if f.omit && f.nonEmpty != "" {
+			g.printf("if %s {\n", f.nonEmpty)
+		}
+		g.printf("b = enc.AppendFieldName(b, %q)\n", f.name)
+		g.generateAppendValue(f)
+		if f.omit && f.nonEmpty != "" {
+			g.printf("}\n")
+		}
+	}
+	if unknown != "" {
+		g.printf("if b, err = enc.AppendUnknownFields(b, %s); err != nil {\nreturn nil, err\n}\n", unknown)
+	}
+	g.printf("return b, nil\n")
+	g.printf("}\n")
+}
+
+func (g *generator) generateAppendValue(f field) {
+	switch f.kind {
+	case kindInt, kindUint:
+		g.printf("b = enc.AppendFixed(b, binary.%s, uint64(%s))\n", f.tag, f.expr)
+	case kindFloat:
+		g.imports["math"] = true
+		if f.tag == "Float32" {
+			g.printf("b = enc.AppendFixed(b, binary.Float32, uint64(math.Float32bits(%s)))\n", f.expr)
+		} else {
+			g.printf("b = enc.AppendFixed(b, binary.Float64, math.Float64bits(%s))\n", f.expr)
+		}
+	case kindBool:
+		g.printf("b = enc.AppendBool(b, %s)\n", f.expr)
+	case kindString:
+		g.printf("b = enc.AppendString(b, %s)\n", f.expr)
+	case kindTime:
+		g.printf("if b, err = enc.AppendTimestamp(b, %s); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+	case kindDuration:
+		g.printf("b = enc.AppendFixed(b, binary.Duration, uint64(%s))\n", f.expr)
+	case kindBytes:
+		g.printf("if b, err = enc.AppendBytes(b, %s); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+	case kindStruct:
+		g.printf("if b, err = %s.AppendBinaryFormat(enc, b); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+	case kindStructPtr, kindStructSlice:
+		// like the Encoder, nil is written as Nil and cycles through the
+		// pointer or slice fail; omitted empty fields are never nil
+		mayBeNil := !f.omit || f.nonEmpty == ""
+		if mayBeNil {
+			g.printf("if %s == nil {\nb = append(b, byte(binary.Nil))\n} else {\n", f.expr)
+		}
+		g.printf("if err = enc.EnterPointer(%s); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+		if f.kind == kindStructPtr {
+			g.printf("if b, err = %s.AppendBinaryFormat(enc, b); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+		} else {
+			g.generateAppendArray(f)
+		}
+		g.printf("enc.LeavePointer(%s)\n", f.expr)
+		if mayBeNil {
+			g.printf("}\n")
+		}
+	case kindStructArray:
+		g.generateAppendArray(f)
+	default:
+		g.printf("if b, err = enc.AppendEncode(b, %s); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+	}
+}
+
+// generateAppendArray appends the header and elements of the slice or array
+// field f.
+func (g *generator) generateAppendArray(f field) {
+	g.printf("if b, err = enc.AppendArrayHeader(b, len(%s)); err != nil {\nreturn nil, enc.FieldError(err, %q)\n}\n", f.expr, f.name)
+	g.printf("for i := range %s {\n", f.expr)
+	g.printf("if b, err = %s[i].AppendBinaryFormat(enc, b); err != nil {\nreturn nil, enc.FieldError(enc.ElementError(err, i), %q)\n}\n", f.expr, f.name)
+	g.printf("}\n")
+}
+
+func (g *generator) generateDecode(name string, fields []field, unknown string) {
+	g.printf("\n// DecodeBinaryFormat implements binary.DecodeUnmarshaler.\n")
+	g.printf("func (x *%s) DecodeBinaryFormat(dec *binary.Decoder, b []byte) (int, error) {\n", name)
+	g.printf("if len(b) > 0 && b[0] == byte(binary.Nil) {\nreturn 1, nil\n}\n")
+	g.printf("l, off, err := dec.DecodeStructHeader(b)\n")
+	g.printf("if err != nil {\nreturn 0, err\n}\n")
+	g.printf("defer dec.EndStruct()\n")
+	if unknown != "" {
+		g.printf("%s = nil\n", unknown)
+	}
+	g.printf("for i := 0; i < l; i++ {\n")
+	if unknown != "" {
+		g.printf("start := off\n")
+	}
+	g.printf("name, n, err := dec.DecodeFieldName(b[off:])\n")
+	g.printf("if err != nil {\nreturn 0, dec.FieldError(err, b, off, nil)\n}\n")
+	g.printf("off += n\n")
+	g.printf("switch string(name) {\n")
+
+	// like the reflection based Decoder, the last of the fields sharing a
+	// name receives its value
+	last := make(map[string]int, len(fields))
+	for i, f := range fields {
+		last[f.name] = i
+	}
+	for i, f := range fields {
+		if last[f.name] != i {
+			continue
+		}
+		g.printf("case %q:\n", f.name)
+		g.generateDecodeValue(f)
+	}
+
+	g.printf("default:\n")
+	if unknown != "" {
+		g.printf("if n, err = dec.Skip(b[off:]); err == nil {\n")
+		g.printf("%s, err = dec.AppendUnknownField(%s, b[start:off+n])\n", unknown, unknown)
+		g.printf("}\n")
+	} else {
+		g.printf("n, err = dec.DecodeUnknownField(name, b[off:])\n")
+	}
+	g.printf("}\n")
+	g.printf("if err != nil {\nreturn 0, dec.FieldError(err, b, off, name)\n}\n")
+	g.printf("off += n\n")
+	g.printf("}\n")
+	g.printf("return off, nil\n")
+	g.printf("}\n")
+}
+
+func (g *generator) generateDecodeValue(f field) {
+	// scalars of another width decode through a temporary, which keeps the
+	// field unchanged when the value is Nil; integers narrower than the
+	// temporary, int and uint included, fail rather than wrap
+	convert := func(method, wide, goType string) {
+		if goType == wide {
+			g.printf("n, err = dec.%s(b[off:], &%s)\n", method, f.expr)
+			return
+		}
+		g.printf("v := %s(%s)\n", wide, f.expr)
+		g.printf("n, err = dec.%s(b[off:], &v)\n", method)
+		switch goType {
+		case "float32", "time.Duration":
+		default:
+			g.printf("if err == nil && %s(%s(v)) != v {\nerr = binary.ErrOverflow\n}\n", wide, goType)
+		}
+		g.printf("%s = %s(v)\n", f.expr, goType)
+	}
+
+	switch f.kind {
+	case kindInt:
+		convert("DecodeInt", "int64", f.goType)
+	case kindUint:
+		convert("DecodeUint", "uint64", f.goType)
+	case kindFloat:
+		convert("DecodeFloat", "float64", f.goType)
+	case kindDuration:
+		g.imports["time"] = true
+		convert("DecodeInt", "int64", "time.Duration")
+	case kindBool:
+		g.printf("n, err = dec.DecodeBool(b[off:], &%s)\n", f.expr)
+	case kindString:
+		g.printf("n, err = dec.DecodeString(b[off:], &%s)\n", f.expr)
+	case kindBytes:
+		g.printf("n, err = dec.DecodeBytes(b[off:], &%s)\n", f.expr)
+	case kindStruct:
+		g.printf("n, err = %s.DecodeBinaryFormat(dec, b[off:])\n", f.expr)
+	case kindStructPtr:
+		g.imports["unsafe"] = true
+		g.printf("if off < len(b) && b[off] == byte(binary.Nil) {\n%s, n = nil, 1\nbreak\n}\n", f.expr)
+		g.printf("if %s == nil {\n", f.expr)
+		g.printf("if err = dec.Allocate(1, unsafe.Sizeof(%s{})); err != nil {\nbreak\n}\n", f.elem)
+		g.printf("%s = new(%s)\n", f.expr, f.elem)
+		g.printf("}\n")
+		g.printf("n, err = %s.DecodeBinaryFormat(dec, b[off:])\n", f.expr)
+	case kindStructSlice, kindStructArray:
+		g.generateDecodeArray(f)
+	default:
+		g.printf("n, err = dec.Decode(b[off:], &%s)\n", f.expr)
+	}
+}
+
+// generateDecodeArray decodes the slice or array field f. Like the Decoder,
+// the field is only set once all elements are decoded.
+func (g *generator) generateDecodeArray(f field) {
+	if f.kind == kindStructSlice {
+		g.imports["unsafe"] = true
+		g.printf("if off < len(b) && b[off] == byte(binary.Nil) {\n%s, n = nil, 1\nbreak\n}\n", f.expr)
+		g.printf("var count, pos int\n")
+		g.printf("if count, pos, err = dec.DecodeArrayHeader(b[off:], unsafe.Sizeof(%s{})); err != nil {\nbreak\n}\n", f.elem)
+		g.printf("v := make([]%s, 0, dec.SliceCap(b[off+pos:], count, unsafe.Sizeof(%s{})))\n", f.elem, f.elem)
+	} else {
+		g.printf("if off < len(b) && b[off] == byte(binary.Nil) {\nn = 1\nbreak\n}\n")
+		g.printf("var count, pos int\n")
+		g.printf("if count, pos, err = dec.DecodeArrayHeader(b[off:], 0); err != nil {\nbreak\n}\n")
+		g.printf("var v %s\n", f.array)
+		g.printf("if count > len(v) {\ndec.EndArray()\nerr = binary.ErrTypeMismatch\nbreak\n}\n")
+	}
+	g.printf("for j := 0; j < count && err == nil; j++ {\n")
+	if f.kind == kindStructSlice {
+		g.printf("v = append(v, %s{})\n", f.elem)
+	}
+	g.printf("if n, err = v[j].DecodeBinaryFormat(dec, b[off+pos:]); err != nil {\n")
+	g.printf("err = dec.ElementError(err, b[off:], pos, j)\n")
+	g.printf("}\n")
+	g.printf("pos += n\n")
+	g.printf("}\n")
+	g.printf("dec.EndArray()\n")
+	g.printf("if err == nil {\n%s, n = v, pos\n}\n", f.expr)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate_UpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "internal", "gentest")
+	want, err := os.ReadFile(filepath.Join(dir, "order_binary.go"))
+	assert.NoError(t, err)
+
+	got, err := generate(dir, []string{"Order", "Item", "Node"})
+	assert.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "run go generate ./internal/gentest")
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		src   string
+		types []string
+		err   string
+	}{
+		{
+			"missing",
+			"package p\n",
+			[]string{"T"},
+			"type T not found",
+		},
+		{
+			"not struct",
+			"package p\ntype T int\n",
+			[]string{"T"},
+			"type T is not a struct type",
+		},
+		{
+			"unknown omitempty",
+			"package p\nimport \"net\"\ntype T struct {\n\tIP net.Addr `binary:\",omitempty\"`\n}\n",
+			[]string{"T"},
+			"field IP of T: cannot tell when a net.Addr is empty for omitempty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "p.go"), []byte(tt.src), 0644))
+
+			_, err := generate(dir, tt.types)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestGenerate_Fields(t *testing.T) {
+	dir := t.TempDir()
+	src := "package p\n" +
+		"type Level int16\n" +
+		"type Z struct{ A int }\n" +
+		"func (z Z) IsZero() bool { return z.A == 0 }\n" +
+		"type T struct {\n" +
+		"\tZ `binary:\"z,omitempty\"`\n" +
+		"\tL Level `binary:\",omitempty\"`\n" +
+		"\tA, B string `binary:\"same\"`\n" +
+		"\tc int\n" +
+		"}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644))
+
+	got, err := generate(dir, []string{"T", "Z"})
+	assert.NoError(t, err)
+	for _, s := range []string{
+		"if !x.Z.IsZero() {",
+		"if x.L != 0 {",
+		"b = enc.AppendFieldName(b, \"z\")",
+		"x.Z.AppendBinaryFormat(enc, b)",
+		"n, err = dec.DecodeString(b[off:], &x.B)",
+	} {
+		assert.Contains(t, string(got), s)
+	}
+	assert.NotContains(t, string(got), "x.c")
+	assert.NotContains(t, string(got), "&x.A)")
+}
@@ -0,0 +1,72 @@
+// Binarygen generates methods that encode and decode struct types in the
+// format of github.com/hysios/binary without reflection. Given the directory
+// of a package and a list of struct types, such as
+//
+//	//go:generate binarygen -type Order,Item
+//
+// it writes a file next to them, order_binary.go by default, declaring the
+// methods AppendBinaryFormat, DecodeBinaryFormat, MarshalBinaryFormat and
+// UnmarshalBinaryFormat for each type. Encode and Decode use them instead of
+// reflection and produce the same bytes.
+//
+// Fields of predeclared scalar types, []byte, time.Time, time.Duration and
+// of the other generated types are encoded inline. Fields of any other type
+// fall back to the reflection based Encoder and Decoder. A field of type
+// binary.UnknownFields keeps unknown fields like it does for Decode.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names; must be set")
+	output    = flag.String("output", "", "output file name; default srcdir/<type>_binary.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of binarygen:\n")
+	fmt.Fprintf(os.Stderr, "\tbinarygen [flags] -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("binarygen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	types := strings.Split(*typeNames, ",")
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, strings.ToLower(types[0])+"_binary.go")
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,421 +1,922 @@
 package binary
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"strconv"
 	"time"
 )
 
 var decoder = &Decoder{}
 
+// arrayTypes holds the slice type an array tag decodes to when the target is
+// an interface.
+var arrayTypes = map[Type]reflect.Type{
+	Array:          reflect.TypeOf([]interface{}{}),
+	ArrayInt:       reflect.TypeOf([]int{}),
+	ArrayUint:      reflect.TypeOf([]uint{}),
+	ArrayFloat:     reflect.TypeOf([]float64{}),
+	ArrayFloat32:   reflect.TypeOf([]float32{}),
+	ArrayString:    reflect.TypeOf([]string{}),
+	ArrayBool:      reflect.TypeOf([]bool{}),
+	ArrayInt8:      reflect.TypeOf([]int8{}),
+	ArrayInt16:     reflect.TypeOf([]int16{}),
+	ArrayInt32:     reflect.TypeOf([]int32{}),
+	ArrayInt64:     reflect.TypeOf([]int64{}),
+	ArrayUint16:    reflect.TypeOf([]uint16{}),
+	ArrayUint32:    reflect.TypeOf([]uint32{}),
+	ArrayUint64:    reflect.TypeOf([]uint64{}),
+	ArrayTimestamp: reflect.TypeOf([]time.Time{}),
+	ArrayDuration:  reflect.TypeOf([]time.Duration{}),
+}
+
+// arrayElems holds the element tag of the typed arrays whose elements have a
+// fixed width and can therefore be Packed.
+var arrayElems = map[Type]Type{
+	ArrayInt:       Int,
+	ArrayUint:      Uint,
+	ArrayFloat:     Float64,
+	ArrayFloat32:   Float32,
+	ArrayBool:      Bool,
+	ArrayInt8:      Int8,
+	ArrayInt16:     Int16,
+	ArrayInt32:     Int32,
+	ArrayInt64:     Int64,
+	ArrayUint16:    Uint16,
+	ArrayUint32:    Uint32,
+	ArrayUint64:    Uint64,
+	ArrayTimestamp: Timestamp,
+	ArrayDuration:  Duration,
+}
+
+// arrayTags is the inverse of arrayTypes.
+var arrayTags = make(map[reflect.Type]Type, len(arrayTypes))
+
+func init() {
+	for typ, t := range arrayTypes {
+		arrayTags[t] = typ
+	}
+}
+
 type Decoder struct {
+	r    *bufio.Reader
+	opts options
+	st   *decodeState
+}
+
+// NewDecoder returns a Decoder that reads successive values from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r)
+}
+
+// NewDecoderWithOptions returns a Decoder configured by opts. When r is not
+// nil the Decoder also reads successive values from it with DecodeNext.
+func NewDecoderWithOptions(r io.Reader, opts ...Option) *Decoder {
+	dec := &Decoder{opts: newOptions(opts)}
+	if r != nil {
+		dec.r = bufio.NewReader(r)
+	}
+	return dec
 }
 
 func (dec *Decoder) Decode(b []byte, val interface{}) (int, error) {
+	return dec.decode(b, val)
+}
+
+// DecodeNext reads the next value from the underlying reader of a Decoder
+// created with NewDecoder and stores it in val. It returns io.EOF when no
+// more values are available. Other errors are *DecodeErrors whose Offset
+// counts from the start of the value read.
+func (dec *Decoder) DecodeNext(val interface{}) error {
+	if dec.r == nil {
+		return ErrNoReader
+	}
+
+	dec = dec.begin()
+	b, err := dec.readValue(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = dec.decode(b, val)
+	return err
+}
+
+func (dec *Decoder) DecodeElement(b []byte, val interface{}) (bool, error) {
+	if len(b) < 1 {
+		return false, ErrBufTooSmall
+	}
+
+	var ref bool
+	switch b[0] {
+	case byte(ElementValue):
+	case byte(ElementRef):
+		ref = true
+	default:
+		return false, ErrInvalidElementType
+	}
+
+	_, err := dec.decode(b[1:], val)
+	if de, ok := err.(*DecodeError); ok {
+		de.Offset++
+	}
+	return ref, err
+}
+
+func (dec *Decoder) decode(b []byte, val interface{}) (int, error) {
+	v := reflect.ValueOf(val)
+	v = reflect.Indirect(v)
+	if !v.CanSet() {
+		return 0, ErrUnsettable
+	}
+
+	n, err := dec.begin().decodeVal(b, v)
+	if err != nil {
+		return 0, rootError(err, b, v.Type())
+	}
+	return n, nil
+}
+
+func (dec *Decoder) decodeVal(b []byte, v reflect.Value) (int, error) {
+	if len(b) == 0 {
+		return 0, ErrBufTooSmall
+	}
+	return typeDecoder(v.Type())(dec, b, v)
+}
+
+// decodeValue decodes b into v by the tag of b, without the checks for
+// pointers, unmarshalers and struct fields compiled into the decoder of the
+// type of v.
+func (dec *Decoder) decodeValue(b []byte, v reflect.Value) (int, error) {
 	if len(b) == 0 {
 		return 0, ErrBufTooSmall
 	}
 
 	typ := Type(b[0])
-	switch typ {
-	case Uint8, Uint16, Uint32, Uint64, Uint, Int8, Int16,
-		Int32, Int64, Int, Bool, Float32, Float64, Timestamp, Duration,
-		String, Bytes:
-		return dec.decode(b, val)
-	case ArrayInt:
-		var (
-			l      = ByteOrder.Uint16(b[1:])
-			v      = reflect.ValueOf(val)
-			nv     = make([]int, l)
-			offset = 3
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		for i := 0; i < int(l); i++ {
-			n, err := dec.decode(b[offset+i*9:], &nv[i])
-			if err != nil {
-				return 0, err
-			}
-			count += n
+	if typ == VarLen {
+		if len(b) < 2 {
+			return 0, ErrBufTooSmall
 		}
-
-		v.Set(reflect.ValueOf(nv))
-		return count, nil
-	case ArrayUint:
-		var (
-			l      = ByteOrder.Uint16(b[1:])
-			v      = reflect.ValueOf(val)
-			nv     = make([]uint, l)
-			offset = 3
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		for i := 0; i < int(l); i++ {
-			n, err := dec.decode(b[offset+i*9:], &nv[i])
-			if err != nil {
-				return 0, err
-			}
-			count += n
+		if typ = Type(b[1]); !varLen(typ) {
+			return 0, ErrInvalidCodec
 		}
+	}
 
-		v.Set(reflect.ValueOf(nv))
-		return count, nil
-	case ArrayFloat32:
-		var (
-			l      = ByteOrder.Uint16(b[1:])
-			v      = reflect.ValueOf(val)
-			nv     = make([]float32, l)
-			offset = 3
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		for i := 0; i < int(l); i++ {
-			n, err := dec.decode(b[offset+i*5:], &nv[i])
-			if err != nil {
-				return 0, err
-			}
-			count += n
+	if n := fixedSize(typ); n > 0 && len(b) < n+1 {
+		return 0, ErrBufTooSmall
+	}
+
+	switch typ {
+	case Nil:
+		switch v.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return 1, nil
+	case Uint8:
+		return 2, dec.setVal(v, b[1])
+	case Uint16:
+		return 3, dec.setVal(v, dec.opts.order().Uint16(b[1:]))
+	case Uint32:
+		return 5, dec.setVal(v, dec.opts.order().Uint32(b[1:]))
+	case Uint64:
+		return 9, dec.setVal(v, dec.opts.order().Uint64(b[1:]))
+	case Int8:
+		return 2, dec.setVal(v, int8(b[1]))
+	case Int16:
+		return 3, dec.setVal(v, int16(dec.opts.order().Uint16(b[1:])))
+	case Int32:
+		return 5, dec.setVal(v, int32(dec.opts.order().Uint32(b[1:])))
+	case Int64:
+		return 9, dec.setVal(v, int64(dec.opts.order().Uint64(b[1:])))
+	case Int:
+		return 9, dec.setVal(v, int(dec.opts.order().Uint64(b[1:])))
+	case Uint:
+		return 9, dec.setVal(v, uint(dec.opts.order().Uint64(b[1:])))
+	case Varint:
+		// int and int64 share the tag, so interfaces get an int64, see
+		// WithCompactInts
+		x, n := binary.Varint(b[1:])
+		if err := varintErr(n); err != nil {
+			return 0, err
 		}
+		return n + 1, dec.setVal(v, x)
+	case Uvarint:
+		x, n := binary.Uvarint(b[1:])
+		if err := varintErr(n); err != nil {
+			return 0, err
+		}
+		return n + 1, dec.setVal(v, x)
+	case Float32:
+		return 5, dec.setVal(v, math.Float32frombits(dec.opts.order().Uint32(b[1:])))
+	case Float64:
+		return 9, dec.setVal(v, math.Float64frombits(dec.opts.order().Uint64(b[1:])))
+	case Bool:
+		return 2, dec.setVal(v, b[1] != 0)
+	case String, VarString:
+		s, n, err := dec.str(b)
+		if err != nil {
+			return 0, err
+		}
+		return n, dec.setVal(v, s)
+	case Bytes:
+		l, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		if err := dec.checkLength(l); err != nil {
+			return 0, err
+		}
+		if l > uint64(len(b)-offset) {
+			return 0, ErrBufTooSmall
+		}
+		end := offset + int(l)
+		return end, dec.setVal(v, b[offset:end])
+	case Timestamp:
+		return 9, dec.setVal(v, timeOf(int64(dec.opts.order().Uint64(b[1:]))))
+	case Duration:
+		return 9, dec.setVal(v, time.Duration(dec.opts.order().Uint64(b[1:])))
+	case Array, ArrayInt, ArrayUint, ArrayFloat32, ArrayFloat, ArrayString, ArrayBool,
+		ArrayInt8, ArrayInt16, ArrayInt32, ArrayInt64, ArrayUint16, ArrayUint32, ArrayUint64,
+		ArrayTimestamp, ArrayDuration:
+		return dec.decodeArray(b, v, arrayTypes[typ])
+	case Packed:
+		return dec.decodePacked(b, v)
+	case BitArray:
+		return dec.decodeBits(b, v)
+	case Map:
+		return dec.decodeMap(b, v)
+	case Struct:
+		return dec.decodeStruct(b, v, nil, -1)
+	default:
+		return 0, ErrInvalidCodec
+	}
+}
 
-		v.Set(reflect.ValueOf(nv))
-		return count, nil
-	case ArrayFloat:
-		var (
-			l      = ByteOrder.Uint16(b[1:])
-			v      = reflect.ValueOf(val)
-			nv     = make([]float64, l)
-			offset = 3
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		for i := 0; i < int(l); i++ {
-			n, err := dec.decode(b[offset+i*9:], &nv[i])
-			if err != nil {
-				return 0, err
-			}
-			count += n
+// timeOf returns the time of the Timestamp bits x, see timeBits.
+func timeOf(x int64) time.Time {
+	if x == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(x/1000000000, x%1000000000).UTC()
+}
+
+// decodeArray decodes an Array* value into the slice or array v. When v is
+// an interface the elements are collected into a slice of typ.
+func (dec *Decoder) decodeArray(b []byte, v reflect.Value, typ reflect.Type) (int, error) {
+	count, offset, err := dec.header(b)
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.checkElements(count); err != nil {
+		return 0, err
+	}
+	// every element takes at least a tag, which is all of a Nil
+	if count > uint64(len(b)-offset) {
+		return 0, ErrCorrupt
+	}
+	l := int(count)
+
+	nv, err := dec.makeSlice(v, typ, l, len(b)-offset)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := dec.enter(); err != nil {
+		return 0, err
+	}
+	defer dec.leave()
+
+	elem := typeDecoder(nv.Type().Elem())
+	for i := 0; i < l; i++ {
+		nv = growSlice(nv, i, l)
+		n, err := elem(dec, b[offset:], nv.Index(i))
+		if err != nil {
+			return 0, decodeError(err, b, offset, nv.Type().Elem().String(), index(i))
 		}
+		offset += n
+	}
 
-		v.Set(reflect.ValueOf(nv))
-		return count, nil
-	case ArrayString:
-		var (
-			l      = ByteOrder.Uint16(b[1:])
-			v      = reflect.ValueOf(val)
-			nv     = make([]string, l)
-			offset = 3
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		for i := 0; i < int(l); i++ {
-			n, err := dec.decode(b[offset:], &nv[i])
-			if err != nil {
-				return 0, err
-			}
-			offset += bytes.Index(b[offset:], []byte{0}) + 1
-			count += n
+	return offset, dec.set(v, nv)
+}
+
+// makeSlice returns the value that l decoded array elements are stored in
+// before it is set into v: a new slice of the type of v, or of typ when v is
+// an interface, or a copy of the array v. Slices are checked against the
+// allocation limit of dec first. They start with no more elements than fit
+// in the room bytes left in the input, and are grown with growSlice as their
+// elements are decoded, so a large count costs memory in step with the input
+// that backs it.
+func (dec *Decoder) makeSlice(v reflect.Value, typ reflect.Type, l, room int) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Interface:
+	case reflect.Slice:
+		typ = v.Type()
+	case reflect.Array:
+		if l > v.Len() {
+			return reflect.Value{}, ErrTypeMismatch
 		}
+		return reflect.New(v.Type()).Elem(), nil
+	default:
+		return reflect.Value{}, ErrTypeMismatch
+	}
 
-		v.Set(reflect.ValueOf(nv))
-		return count, nil
-	case Map:
-		var (
-			l      = ByteOrder.Uint32(b[1:])
-			v      = reflect.ValueOf(val)
-			offset = 5
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		t := v.Type()
+	if err := dec.allocate(l, typ.Elem().Size()); err != nil {
+		return reflect.Value{}, err
+	}
+	n := sliceCap(l, typ.Elem().Size(), room)
+	return reflect.MakeSlice(typ, n, n), nil
+}
 
-		for i := 0; i < int(l); i++ {
-			if Type(b[offset]) != MapKey {
-				return 0, ErrInvalidMapKey
-			}
+// sliceCap returns the number of elements of size bytes to allocate up front
+// for l elements decoded from room bytes of input.
+func sliceCap(l int, size uintptr, room int) int {
+	if room < 0 {
+		room = 0
+	}
+	if size > 0 && uintptr(l) > uintptr(room)/size {
+		return int(uintptr(room) / size)
+	}
+	return l
+}
 
-			offset++
-			key := reflect.New(t.Key())
-			n, err := dec.decode(b[offset:], key.Interface())
-			if err != nil {
-				return 0, err
-			}
+// growSlice returns s, or a copy of the slice s twice as long but at most l
+// elements when its element i is past the end.
+func growSlice(s reflect.Value, i, l int) reflect.Value {
+	if i < s.Len() {
+		return s
+	}
+	n := 2 * s.Len()
+	if n <= i {
+		n = i + 1
+	}
+	if n > l {
+		n = l
+	}
+	ns := reflect.MakeSlice(s.Type(), n, n)
+	reflect.Copy(ns, s)
+	return ns
+}
 
-			offset += n
-			if Type(b[offset]) != MapValue {
-				return 0, ErrInvalidMapKey
-			}
-			offset++
-			val := reflect.New(t.Elem()).Elem()
-			n, err = dec.decodeVal(b[offset:], val)
-			if err != nil {
-				return 0, err
-			}
-			count += n
-			offset += n
+// index returns the path segment of the element i of an array.
+func index(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
 
-			v.SetMapIndex(key.Elem(), val)
-		}
+// decodePacked decodes a Packed array into the slice or array v. Elements
+// of the natural Go type of the array are copied in bulk.
+func (dec *Decoder) decodePacked(b []byte, v reflect.Value) (int, error) {
+	typ, elem, l, offset, err := dec.packedHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.checkElements(uint64(l)); err != nil {
+		return 0, err
+	}
 
-		return count, nil
-	case Struct:
-		var (
-			l      = ByteOrder.Uint32(b[1:])
-			v      = reflect.ValueOf(val)
-			offset = 5
-			count  = offset
-		)
-		v = reflect.Indirect(v)
-		t := v.Type()
-		for i := 0; i < int(l); i++ {
-			if Type(b[offset]) != StructField {
-				return 0, ErrInvalidStructField
-			}
+	var (
+		size = fixedSize(elem)
+		data = b[offset : offset+l*size]
+	)
+	nv, err := dec.makeSlice(v, arrayTypes[typ], l, len(data))
+	if err != nil {
+		return 0, err
+	}
 
-			offset++
-			var key string
-			idx := bytes.Index(b[offset:], []byte{0})
-			if idx <= 0 {
-				return 0, ErrInvalidStructField
+	if nv.Len() < l || nv.Type() != arrayTypes[typ] || !dec.copyPacked(nv.Interface(), data) {
+		var tmp [9]byte
+		tmp[0] = byte(elem)
+		for i := 0; i < l; i++ {
+			nv = growSlice(nv, i, l)
+			copy(tmp[1:], data[i*size:(i+1)*size])
+			if _, err := dec.decodeVal(tmp[:size+1], nv.Index(i)); err != nil {
+				de := decodeError(err, tmp[:], 0, nv.Type().Elem().String(), index(i))
+				de.Offset += offset + i*size
+				return 0, de
 			}
+		}
+	}
 
-			key = string(b[offset : offset+idx])
+	return offset + len(data), dec.set(v, nv)
+}
 
-			offset += idx + 1
-			if Type(b[offset]) != StructValue {
-				return 0, ErrInvalidStructValue
-			}
+// decodeBits decodes a BitArray into the slice or array v. Bits are set in
+// bulk into bools, and decoded as a Bool each into other elements, as an
+// ArrayBool would be.
+func (dec *Decoder) decodeBits(b []byte, v reflect.Value) (int, error) {
+	l, offset, err := dec.bitsHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.checkElements(uint64(l)); err != nil {
+		return 0, err
+	}
 
-			ft, ok := t.FieldByName(key)
-			if !ok {
-				return 0, fmt.Errorf("missing struct field %s", key)
-			}
-			offset++
-			val := reflect.New(ft.Type).Elem()
-			n, err := dec.decodeVal(b[offset:], val)
-			if err != nil {
-				return 0, err
-			}
-			count += n
-			offset += n
+	bits := b[offset:]
+	room := (l + 7) / 8
+	if k := v.Kind(); k == reflect.Interface || k == reflect.Slice && v.Type().Elem().Kind() == reflect.Bool {
+		// every bit decodes into a bool, so the slice is made whole
+		room = l
+	}
+	nv, err := dec.makeSlice(v, arrayTypes[ArrayBool], l, room)
+	if err != nil {
+		return 0, err
+	}
 
-			v.FieldByName(key).Set(val)
-			// offset++
-			// count++
-			// v.SetMapIndex(key.Elem(), val)
+	if nv.Type().Elem().Kind() == reflect.Bool {
+		for i := 0; i < l; i++ {
+			nv.Index(i).SetBool(bits[i/8]&(1<<(i%8)) != 0)
 		}
-		return count, nil
-	default:
-		return 0, errors.New("invalid codec of decode")
+		return offset + (l+7)/8, dec.set(v, nv)
 	}
+
+	tmp := [2]byte{byte(Bool)}
+	for i := 0; i < l; i++ {
+		nv = growSlice(nv, i, l)
+		tmp[1] = bits[i/8] >> (i % 8) & 1
+		if _, err := dec.decodeVal(tmp[:], nv.Index(i)); err != nil {
+			de := decodeError(err, tmp[:], 0, nv.Type().Elem().String(), index(i))
+			de.Offset += offset + i/8
+			return 0, de
+		}
+	}
+	return offset + (l+7)/8, dec.set(v, nv)
 }
 
-func (dec *Decoder) DecodeElement(b []byte, val interface{}) (bool, error) {
-	if len(b) < 1 {
-		return false, ErrBufTooSmall
+// bitsHeader returns the element count of the BitArray at the start of b and
+// the offset of its first byte of bits, after checking that b holds them all.
+func (dec *Decoder) bitsHeader(b []byte) (int, int, error) {
+	l, n := binary.Uvarint(b[1:])
+	switch {
+	case n == 0:
+		return 0, 0, ErrBufTooSmall
+	case n < 0 || l > maxArrayLen:
+		return 0, 0, ErrCorrupt
 	}
 
-	switch b[0] {
-	case byte(ElementValue):
-		if _, err := dec.decode(b[1:], val); err != nil {
-			return false, err
+	offset := n + 1
+	if (l+7)/8 > uint64(len(b)-offset) {
+		return 0, 0, ErrBufTooSmall
+	}
+	// at eight a byte the bits may still outnumber an int on 32-bit
+	// platforms
+	if l > math.MaxInt {
+		return 0, 0, ErrCorrupt
+	}
+	return int(l), offset, nil
+}
+
+// copyPacked copies the packed elements in data into the slice x of their
+// natural Go type, reporting false when x can not be filled in bulk.
+func (dec *Decoder) copyPacked(x interface{}, data []byte) bool {
+	order := dec.opts.order()
+	switch x := x.(type) {
+	case []int:
+		for i := range x {
+			x[i] = int(order.Uint64(data[i*8:]))
 		}
-		return false, nil
-	case byte(ElementRef):
-		if _, err := dec.decode(b[1:], val); err != nil {
-			return true, err
+		return true
+	case []uint:
+		for i := range x {
+			x[i] = uint(order.Uint64(data[i*8:]))
 		}
-		return true, nil
+		return true
+	case []int8, []int16, []int32, []int64, []uint16, []uint32, []uint64,
+		[]float32, []float64, []bool:
+		return binary.Read(bytes.NewReader(data), order, x) == nil
 	default:
-		return false, ErrInvalidElementType
+		return false
 	}
 }
 
-func (dec *Decoder) decode(b []byte, val interface{}) (int, error) {
-	if len(b) == 0 {
-		return 0, ErrBufTooSmall
+// packedHeader returns the array tag, element tag and element count of the
+// Packed array at the start of b and the offset of its first element, after
+// checking that b holds all of its elements.
+func (dec *Decoder) packedHeader(b []byte) (Type, Type, int, int, error) {
+	if len(b) < 2 {
+		return 0, 0, 0, 0, ErrBufTooSmall
 	}
 
-	v := reflect.ValueOf(val)
-	v = reflect.Indirect(v)
-	if !v.CanSet() {
-		return 0, errors.New("decode to value must can be set")
+	typ := Type(b[1])
+	elem, ok := arrayElems[typ]
+	if !ok {
+		return 0, 0, 0, 0, ErrInvalidCodec
 	}
 
-	return dec.decodeVal(b, v)
+	l, n := binary.Uvarint(b[2:])
+	switch {
+	case n == 0:
+		return 0, 0, 0, 0, ErrBufTooSmall
+	case n < 0 || l > maxArrayLen:
+		return 0, 0, 0, 0, ErrCorrupt
+	}
+
+	offset := n + 2
+	if l > uint64(len(b)-offset)/uint64(fixedSize(elem)) {
+		return 0, 0, 0, 0, ErrBufTooSmall
+	}
+	return typ, elem, int(l), offset, nil
 }
 
-func (dec *Decoder) decodeVal(b []byte, v reflect.Value) (int, error) {
-	typ := Type(b[0])
-	switch typ {
-	case Uint8:
+// header returns the count of the array, map or struct header at the start
+// of b, or the length of a Bytes header, and the offset of the first byte
+// following it. It reads the uint16 array header, the uint32 header of the
+// other tags and the VarLen prefixed uvarint header. The count is left
+// unsigned, as it may not fit an int, until it is checked against b.
+func (dec *Decoder) header(b []byte) (uint64, int, error) {
+	switch Type(b[0]) {
+	case VarLen:
 		if len(b) < 2 {
-			return 0, ErrBufTooSmall
+			return 0, 0, ErrBufTooSmall
 		}
-		// v.SetUint(uint64(b[1]))
-		dec.setVal(v, uint64(b[1]))
-		return 2, nil
-	case Uint16:
+	case Bytes, Map, Struct:
+		if len(b) < 5 {
+			return 0, 0, ErrBufTooSmall
+		}
+		return uint64(dec.opts.order().Uint32(b[1:])), 5, nil
+	default:
 		if len(b) < 3 {
-			return 0, ErrBufTooSmall
+			return 0, 0, ErrBufTooSmall
 		}
-		dec.setVal(v, uint64(ByteOrder.Uint16(b[1:])))
-		// v.SetUint(uint64(ByteOrder.Uint16(b[1:])))
-		return 3, nil
-	case Uint32:
-		if len(b) < 5 {
-			return 0, ErrBufTooSmall
+		return uint64(dec.opts.order().Uint16(b[1:])), 3, nil
+	}
+
+	l, n := binary.Uvarint(b[2:])
+	if err := varintErr(n); err != nil {
+		return 0, 0, err
+	}
+	if l > maxArrayLen {
+		return 0, 0, ErrCorrupt
+	}
+	return l, n + 2, nil
+}
+
+// varLen reports whether typ may follow a VarLen prefix.
+func varLen(typ Type) bool {
+	switch typ {
+	case Bytes, Map, Struct:
+		return true
+	}
+	_, ok := arrayTypes[typ]
+	return ok
+}
+
+// varintErr returns the error for the byte count n reported by
+// binary.Varint or binary.Uvarint, or nil when a value was read.
+func varintErr(n int) error {
+	switch {
+	case n == 0:
+		return ErrBufTooSmall
+	case n < 0:
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// sized returns the payload of the uvarint length prefixed value at the
+// start of b and the length of the whole value, tag included.
+func (dec *Decoder) sized(b []byte) ([]byte, int, error) {
+	l, n := binary.Uvarint(b[1:])
+	switch {
+	case n == 0:
+		return nil, 0, ErrBufTooSmall
+	case n < 0:
+		return nil, 0, ErrCorrupt
+	}
+
+	if err := dec.checkLength(l); err != nil {
+		return nil, 0, err
+	}
+
+	offset := n + 1
+	if uint64(len(b)-offset) < l {
+		return nil, 0, ErrBufTooSmall
+	}
+	return b[offset : offset+int(l)], offset + int(l), nil
+}
+
+// str returns the String or VarString at the start of b and its length, tag
+// included, charging the string to the allocation limit of dec.
+func (dec *Decoder) str(b []byte) (string, int, error) {
+	var (
+		s   []byte
+		n   int
+		err error
+	)
+	switch Type(b[0]) {
+	case String:
+		p := bytes.IndexByte(b[1:], 0)
+		if p < 0 {
+			return "", 0, ErrNonStringTailZero
 		}
-		// v.SetUint(uint64(ByteOrder.Uint32(b[1:])))
-		dec.setVal(v, uint64(ByteOrder.Uint32(b[1:])))
-		return 5, nil
-	case Uint64:
-		if len(b) < 9 {
-			return 0, ErrBufTooSmall
+		if err := dec.checkLength(uint64(p)); err != nil {
+			return "", 0, err
 		}
-		dec.setVal(v, ByteOrder.Uint64(b[1:]))
-		// v.SetUint(ByteOrder.Uint64(b[1:]))
-		return 9, nil
-	case Int8:
-		if len(b) < 2 {
-			return 0, ErrBufTooSmall
+		s, n = b[1:p+1], p+2
+	case VarString:
+		if s, n, err = dec.sized(b); err != nil {
+			return "", 0, err
 		}
-		v.SetInt(int64(b[1]))
-		return 2, nil
-	case Int16:
-		if len(b) < 3 {
-			return 0, ErrBufTooSmall
+	default:
+		return "", 0, ErrTypeMismatch
+	}
+
+	if err := dec.allocate(len(s), 1); err != nil {
+		return "", 0, err
+	}
+	return string(s), n, nil
+}
+
+// fieldName returns the name of the StructField or VarStructField mark at
+// the start of b and the length of the mark and name. The name shares the
+// memory of b.
+func (dec *Decoder) fieldName(b []byte) ([]byte, int, error) {
+	switch Type(b[0]) {
+	case StructField:
+		idx := bytes.IndexByte(b[1:], 0)
+		if idx <= 0 {
+			return nil, 0, ErrInvalidStructField
 		}
-		v.SetInt(int64(ByteOrder.Uint16(b[1:])))
-		return 3, nil
-	case Int32:
-		if len(b) < 5 {
-			return 0, ErrBufTooSmall
+		return b[1 : idx+1], idx + 2, nil
+	case VarStructField:
+		name, n, err := dec.sized(b)
+		if err != nil {
+			return nil, 0, err
 		}
-		v.SetInt(int64(ByteOrder.Uint32(b[1:])))
-		return 5, nil
-	case Int64:
-		if len(b) < 9 {
-			return 0, ErrBufTooSmall
+		if len(name) == 0 {
+			return nil, 0, ErrInvalidStructField
 		}
-		v.SetInt(int64(ByteOrder.Uint64(b[1:])))
-		return 9, nil
-	case Int:
-		if len(b) < 9 {
-			return 0, ErrBufTooSmall
+		return name, n, nil
+	default:
+		return nil, 0, ErrInvalidStructField
+	}
+}
+
+// decodeMap decodes a Map value into v. When v is an interface the entries
+// are collected into a map[interface{}]interface{}.
+func (dec *Decoder) decodeMap(b []byte, v reflect.Value) (int, error) {
+	count, offset, err := dec.header(b)
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.checkElements(count); err != nil {
+		return 0, err
+	}
+
+	mv := v
+	// every entry takes at least the two marks and two tags
+	if count > uint64(len(b)-offset)/4 {
+		return 0, ErrCorrupt
+	}
+	l := int(count)
+
+	switch v.Kind() {
+	case reflect.Interface:
+		mv = reflect.ValueOf(map[interface{}]interface{}(nil))
+	case reflect.Map:
+	default:
+		return 0, ErrTypeMismatch
+	}
+	var (
+		t      = mv.Type()
+		keyDec = typeDecoder(t.Key())
+		valDec = typeDecoder(t.Elem())
+	)
+	if err := dec.allocate(l, t.Key().Size()+t.Elem().Size()); err != nil {
+		return 0, err
+	}
+	if mv.IsNil() {
+		mv = reflect.MakeMap(t)
+	}
+
+	if err := dec.enter(); err != nil {
+		return 0, err
+	}
+	defer dec.leave()
+	for i := 0; i < l; i++ {
+		if offset >= len(b) {
+			return 0, decodeError(ErrBufTooSmall, b, offset, "map key", "")
 		}
-		// v.SetInt(int64(ByteOrder.Uint64(b[1:])))
-		dec.setVal(v, int(ByteOrder.Uint64(b[1:])))
-		return 9, nil
-	case Uint:
-		if len(b) < 9 {
-			return 0, ErrBufTooSmall
+		if Type(b[offset]) != MapKey {
+			return 0, decodeError(ErrInvalidMapKey, b, offset, "map key", "")
 		}
-		// v.SetUint(ByteOrder.Uint64(b[1:]))
-		dec.setVal(v, ByteOrder.Uint64(b[1:]))
-		return 9, nil
-	case Float32:
-		var (
-			buf = bytes.NewBuffer(b[1:])
-			val float32
-		)
-		if len(b) < 5 {
-			return 0, ErrBufTooSmall
+
+		offset++
+		key := reflect.New(t.Key()).Elem()
+		n, err := keyDec(dec, b[offset:], key)
+		if err != nil {
+			return 0, decodeError(err, b, offset, t.Key().String(), "")
 		}
-		if err := binary.Read(buf, ByteOrder, &val); err != nil {
-			return 0, err
+		if !hashable(key) {
+			return 0, decodeError(ErrCorrupt, b, offset, t.Key().String(), "")
 		}
-		v.Set(reflect.ValueOf(val))
-		return 5, nil
-	case Float64:
-		var (
-			buf = bytes.NewBuffer(b[1:])
-			val float64
-		)
-		if len(b) < 9 {
-			return 0, ErrBufTooSmall
+
+		offset += n
+		if offset >= len(b) {
+			return 0, decodeError(ErrBufTooSmall, b, offset, "map value", "")
 		}
-		if err := binary.Read(buf, ByteOrder, &val); err != nil {
-			return 0, err
+		if Type(b[offset]) != MapValue {
+			return 0, decodeError(ErrInvalidMapValue, b, offset, "map value", "")
 		}
-		v.Set(reflect.ValueOf(val))
-		return 9, nil
-	case Bool:
-		var (
-			buf = bytes.NewBuffer(b[1:])
-			val bool
-		)
-		if len(b) < 2 {
-			return 0, ErrBufTooSmall
+		offset++
+		val := reflect.New(t.Elem()).Elem()
+		n, err = valDec(dec, b[offset:], val)
+		if err != nil {
+			return 0, decodeError(err, b, offset, t.Elem().String(), fmt.Sprintf("[%v]", key))
 		}
-		if err := binary.Read(buf, ByteOrder, &val); err != nil {
+		offset += n
+
+		mv.SetMapIndex(key, val)
+	}
+
+	return offset, dec.set(v, mv)
+}
+
+// fieldSize is the size of an entry of the map a Struct decodes to when the
+// target is an interface.
+var fieldSize = reflect.TypeOf("").Size() + reflect.TypeOf((*interface{})(nil)).Elem().Size()
+
+// decodeStruct decodes a Struct value into v using the decoders of its
+// fields by name, collecting the fields it has no decoder for into its field
+// unknown unless that is -1. When v is an interface the fields are collected
+// into a map[string]interface{} instead.
+func (dec *Decoder) decodeStruct(b []byte, v reflect.Value, decs map[string]fieldDecoder, unknown int) (int, error) {
+	l, offset, err := dec.DecodeStructHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.EndStruct()
+
+	var (
+		t      = v.Type()
+		fields map[string]interface{}
+		anyDec decoderFunc
+	)
+	switch {
+	case v.Kind() == reflect.Interface:
+		if err := dec.allocate(l, fieldSize); err != nil {
 			return 0, err
 		}
-		v.Set(reflect.ValueOf(val))
-		return 2, nil
-	case String:
-		p := bytes.IndexByte(b[1:], 0)
-		if p < 0 {
-			return 0, ErrNonStringTailZero
+		fields = make(map[string]interface{}, l)
+		anyDec = typeDecoder(t)
+	case decs == nil:
+		return 0, ErrTypeMismatch
+	}
+
+	var extra UnknownFields
+	for i := 0; i < l; i++ {
+		start := offset
+		name, n, err := dec.DecodeFieldName(b[offset:])
+		if err != nil {
+			return 0, decodeError(err, b, offset, "struct field", "")
 		}
-		// v.SetString(string(b[1 : p+1]))
-		v.Set(reflect.ValueOf(string(b[1 : p+1])))
-		return p + 2, nil
-	case Bytes:
-		l := ByteOrder.Uint32(b[1:])
-		// v.SetBytes(b[5 : l+5])
-		dec.setVal(v, b[5:l+5])
-		return int(l) + 5, nil
-	case Timestamp:
+		offset += n
+
 		var (
-			buf = bytes.NewBuffer(b[1:])
-			dt  int64
+			val reflect.Value
+			fd  = fieldDecoder{dec: anyDec}
 		)
-		if err := binary.Read(buf, ByteOrder, &dt); err != nil {
-			return 0, err
+		if fields != nil {
+			val = reflect.New(t).Elem()
+		} else {
+			var ok bool
+			if fd, ok = decs[string(name)]; !ok {
+				if unknown < 0 {
+					n, err = dec.DecodeUnknownField(name, b[offset:])
+				} else if n, err = dec.skip(b[offset:]); err == nil {
+					extra, err = dec.appendUnknownField(extra, b[start:offset+n])
+				}
+				if err != nil {
+					return 0, decodeError(err, b, offset, "", "."+string(name))
+				}
+				offset += n
+				continue
+			}
+			val = v.Field(fd.index)
 		}
-		t := time.Unix(dt/1000000000, dt%1000000000).UTC()
 
-		v.Set(reflect.ValueOf(t))
-		return 9, nil
-	case Duration:
-		var (
-			buf = bytes.NewBuffer(b[1:])
-			dt  int64
-		)
-		if err := binary.Read(buf, ByteOrder, &dt); err != nil {
-			return 0, err
+		n, err = fd.dec(dec, b[offset:], val)
+		if err != nil {
+			return 0, decodeError(err, b, offset, val.Type().String(), "."+string(name))
 		}
-		// v.SetInt(dt)
-		dec.setVal(v, dt)
-		return 9, nil
-	default:
-		return 0, errors.New("invalid codec of decode")
-	}
-}
-
-func (dec *Decoder) setVal(v reflect.Value, val interface{}) {
-	if v.Kind() == reflect.Interface {
-		v.Set(reflect.ValueOf(val))
-	} else {
-		switch x := val.(type) {
-		case uint64:
-			v.SetUint(x)
-		case int64:
-			v.SetInt(x)
-		case int:
-			v.Set(reflect.ValueOf(x))
-		case float64:
-			v.SetFloat(x)
-		case float32:
-			v.SetFloat(float64(x))
-		case []byte:
-			v.SetBytes(x)
+		offset += n
+
+		if fields != nil {
+			fields[string(name)] = val.Interface()
+		}
+	}
+
+	if fields != nil {
+		return offset, dec.set(v, reflect.ValueOf(fields))
+	}
+	if unknown >= 0 {
+		v.Field(unknown).SetBytes(extra)
+	}
+	return offset, nil
+}
+
+// set stores nv into v, failing instead of panicking when the types are not
+// assignable.
+func (dec *Decoder) set(v, nv reflect.Value) error {
+	if !nv.Type().AssignableTo(v.Type()) {
+		return ErrTypeMismatch
+	}
+	v.Set(nv)
+	return nil
+}
+
+// setVal stores a decoded scalar into v, converting between numeric kinds
+// when the destination is not the natural Go type of the wire value. Integers
+// that do not fit v fail with ErrOverflow rather than wrapping.
+func (dec *Decoder) setVal(v reflect.Value, val interface{}) error {
+	rv := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var x int64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			x = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() > math.MaxInt64 {
+				return ErrOverflow
+			}
+			x = int64(rv.Uint())
+		default:
+			return ErrTypeMismatch
+		}
+		if v.OverflowInt(x) {
+			return ErrOverflow
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var x uint64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if rv.Int() < 0 {
+				return ErrOverflow
+			}
+			x = uint64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			x = rv.Uint()
 		default:
-			panic("invalid type")
+			return ErrTypeMismatch
+		}
+		if v.OverflowUint(x) {
+			return ErrOverflow
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+			return ErrTypeMismatch
+		}
+		v.SetFloat(rv.Float())
+	case reflect.Bool:
+		if rv.Kind() != reflect.Bool {
+			return ErrTypeMismatch
+		}
+		v.SetBool(rv.Bool())
+	case reflect.String:
+		if rv.Kind() != reflect.String {
+			return ErrTypeMismatch
 		}
+		v.SetString(rv.String())
+	case reflect.Slice:
+		if rv.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+			return ErrTypeMismatch
+		}
+		v.SetBytes(rv.Bytes())
+	default:
+		return dec.set(v, rv)
+	}
+	return nil
+}
+
+// hashable reports whether v can be used as a map key without panicking.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return v.Type().Comparable()
 	}
 }
 
@@ -1,6 +1,10 @@
 package binary
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
 	"reflect"
 	"testing"
 	"time"
@@ -34,6 +38,8 @@ func TestDecoder_Decode(t *testing.T) {
 	fs := make([]float32, 0)
 	ffs := make([]float64, 0)
 	ss := make([]string, 0)
+	bls := make([]bool, 0)
+	as := make([]interface{}, 0)
 	mm := make(map[string]interface{})
 
 	type testStruct struct {
@@ -85,6 +91,12 @@ func TestDecoder_Decode(t *testing.T) {
 			0xF4, 0x0E, 0x2D, 0xB2, 0x9D, 0xEF, 0x27, 0x1B, 0x40,
 		}, &ffs, []float64{1.23, 3.45, 6.789}, false),
 		testDecode(ArrayString, []byte{0x02, 0x00, 0xF3, 'h', 'e', 'l', 'l', 'o', 0x00, 0xF3, 'w', 'o', 'r', 'l', 'd', 0x00}, &ss, []string{"hello", "world"}, false),
+		testDecode(ArrayBool, []byte{0x04, 0, 0xF2, 01, 0xF2, 00, 0xF2, 0x01, 0xF2, 00}, &bls, []bool{true, false, true, false}, false),
+		testDecode(Array, []byte{0x03, 0x00,
+			0xF3, 'h', 'i', 0x00,
+			0xF7, 0x02, 0, 0, 0, 0, 0, 0, 0,
+			0xE7, 0x01, 0x00, 0xF2, 0x01,
+		}, &as, []interface{}{"hi", 2, []bool{true}}, false),
 		testDecode(Map, []byte{4, 0, 0, 0, 0xe2, 0xF3, 'h', 'e', 'l', 'l', 'o', 0x00, 0xE1, 0xF3, 'w', 'o', 'r', 'l', 'd', 0x00,
 			0xe2, 0xf3, 0xe4, 0xb8, 0x96, 0xe7, 0x95, 0x8c, 0x00, 0xe1, 0xf3, 0xe4, 0xbd, 0xa0, 0xe5, 0xa5, 0xbd, 0x00,
 			0xe2, 0xf3, 'c', 'o', 'u', 'n', 't', 0, 0xe1, 0xf7, 0x1, 0, 0, 0, 0, 0, 0, 0,
@@ -145,6 +157,8 @@ func TestDecodeTo(t *testing.T) {
 		testDecodeTo(Uint32, []byte{4, 0, 0, 0}, uint32(4), false),
 		testDecodeTo(Uint64, []byte{8, 0, 0, 0, 0, 0, 0, 0}, uint64(8), false),
 		testDecodeTo(String, []byte{'h', 'e', 'l', 'l', 'o', 0}, "hello", false),
+		testDecodeTo(ArrayBool, []byte{0x02, 0, 0xF2, 0x01, 0xF2, 0x00}, []bool{true, false}, false),
+		testDecodeTo(Array, []byte{0x02, 0, 0xF2, 0x01, 0xF3, 'a', 0x00}, []interface{}{true, "a"}, false),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -159,3 +173,948 @@ func TestDecodeTo(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoder_DecodeNested(t *testing.T) {
+	type item struct {
+		Name string
+		Tags []string
+	}
+
+	type order struct {
+		ID     uint32
+		Item   item
+		Prices []float64
+		Counts map[string]map[string]int
+		Data   []byte
+	}
+
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+	}{
+		{
+			name: "struct",
+			val: order{
+				ID:     7,
+				Item:   item{Name: "apple", Tags: []string{"red", "fruit"}},
+				Prices: []float64{1.5, 2.25},
+				Counts: map[string]map[string]int{
+					"north": {"a": 1, "b": 2},
+					"south": {"c": 3},
+				},
+				Data: []byte("raw"),
+			},
+			out: new(order),
+		},
+		{
+			name: "map",
+			val: map[string]map[string]int{
+				"x": {"one": 1},
+				"y": {"two": 2, "three": 3},
+			},
+			out: new(map[string]map[string]int),
+		},
+		{
+			name: "map of arrays",
+			val: map[string][]int{
+				"odd":  {1, 3, 5},
+				"even": {2, 4},
+			},
+			out: new(map[string][]int),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(tt.val)
+			assert.NoError(t, err)
+
+			n, err := Decode(b, tt.out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.val, reflect.ValueOf(tt.out).Elem().Interface())
+		})
+	}
+}
+
+func TestDecodeTo_Nested(t *testing.T) {
+	b, err := Encode(map[string]interface{}{
+		"list": []string{"a", "b"},
+		"sub":  map[string]interface{}{"n": 1},
+	})
+	assert.NoError(t, err)
+
+	got, err := DecodeTo(b)
+	assert.NoError(t, err)
+	assert.Equal(t, map[interface{}]interface{}{
+		"list": []string{"a", "b"},
+		"sub":  map[interface{}]interface{}{"n": 1},
+	}, got)
+}
+
+func TestDecoder_DecodeStructTags(t *testing.T) {
+	type v1 struct {
+		Name  string `binary:"name"`
+		Price int    `binary:"price,omitempty"`
+		Temp  string `binary:"-"`
+	}
+
+	type v2 struct {
+		Title string `binary:"name"`
+		Cost  int    `binary:"price"`
+	}
+
+	b, err := Encode(v1{Name: "apple", Price: 3, Temp: "skip"})
+	assert.NoError(t, err)
+
+	var out v2
+	_, err = Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, v2{Title: "apple", Cost: 3}, out)
+
+	var back v1
+	_, err = Decode(b, &back)
+	assert.NoError(t, err)
+	assert.Equal(t, v1{Name: "apple", Price: 3}, back)
+}
+
+func TestDecoder_DecodeUnknownFields(t *testing.T) {
+	type v1 struct {
+		ID   int
+		Name string
+	}
+
+	type v2 struct {
+		ID    int
+		Tags  []string
+		Name  string
+		Attrs map[string]interface{}
+		Child *v2
+		Raw   []byte
+	}
+
+	in := v2{
+		ID:    7,
+		Tags:  []string{"a", "b"},
+		Name:  "new",
+		Attrs: map[string]interface{}{"k": []interface{}{"v", true}},
+		Child: &v2{ID: 8, Raw: []byte{1}},
+		Raw:   []byte{2, 3},
+	}
+
+	for name, opts := range optionSets {
+		t.Run(name, func(t *testing.T) {
+			b, err := NewEncoderWithOptions(nil, opts...).Encode(in)
+			assert.NoError(t, err)
+
+			var out v1
+			n, err := NewDecoderWithOptions(nil, opts...).Decode(b, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, v1{ID: 7, Name: "new"}, out)
+
+			strict := NewDecoderWithOptions(nil, append(opts, WithDisallowUnknownFields())...)
+			_, err = strict.Decode(b, &v1{})
+			assert.True(t, errors.Is(err, ErrUnknownField), "got %v", err)
+			assert.Contains(t, err.Error(), `"Tags"`)
+
+			var all v2
+			_, err = strict.Decode(b, &all)
+			assert.NoError(t, err)
+			assert.Equal(t, in, all)
+		})
+	}
+}
+
+func TestDecoder_DecodeMalformed(t *testing.T) {
+	type testStruct struct {
+		Name string
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		val  interface{}
+		err  error
+	}{
+		{"empty", nil, new(interface{}), ErrBufTooSmall},
+		{"short uint32", []byte{byte(Uint32), 1, 2}, new(uint32), ErrBufTooSmall},
+		{"short array header", []byte{byte(ArrayInt), 1}, new([]int), ErrBufTooSmall},
+		{"array count", []byte{byte(ArrayInt), 0xFF, 0xFF, byte(Int)}, new([]int), ErrCorrupt},
+		{"array element", []byte{byte(ArrayInt), 1, 0, byte(Int), 1}, new([]int), ErrBufTooSmall},
+		{"bytes length", []byte{byte(Bytes), 0xFF, 0, 0, 0, 'a'}, new([]byte), ErrBufTooSmall},
+		{"map count", []byte{byte(Map), 0xFF, 0xFF, 0xFF, 0xFF}, new(map[string]int), ErrCorrupt},
+		{"map truncated", []byte{byte(Map), 1, 0, 0, 0, byte(MapKey), byte(Uint8), 1, byte(MapValue)}, new(map[uint8]int), ErrBufTooSmall},
+		{"struct truncated", []byte{byte(Struct), 1, 0, 0, 0, byte(StructField), 'N', 'a', 'm', 'e', 0}, new(testStruct), ErrBufTooSmall},
+		{"unknown tag", []byte{0x01}, new(interface{}), ErrInvalidCodec},
+		{"type mismatch", []byte{byte(String), 'a', 0}, new(int), ErrTypeMismatch},
+		{"bytes into ints", []byte{byte(Bytes), 1, 0, 0, 0, 1}, new([]int), ErrTypeMismatch},
+		{"array into struct", []byte{byte(ArrayInt), 0, 0}, new(testStruct), ErrTypeMismatch},
+		{"unhashable key", []byte{byte(Map), 1, 0, 0, 0, byte(MapKey), byte(ArrayInt), 0, 0, byte(MapValue), byte(Uint8), 1}, new(interface{}), ErrCorrupt},
+		{"not a pointer", []byte{byte(Uint8), 1}, uint8(0), ErrUnsettable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.b, tt.val)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestDecoder_DecodeOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+		err  error
+	}{
+		{"fits", int64(-128), new(int8), nil},
+		{"too large", int64(300), new(int8), ErrOverflow},
+		{"too small", int64(-129), new(int8), ErrOverflow},
+		{"unsigned", uint8(255), new(int8), ErrOverflow},
+		{"negative", -1, new(uint), ErrOverflow},
+		{"unsigned fits", uint16(65535), new(uint16), nil},
+		{"above int64", uint64(math.MaxUint64), new(int64), ErrOverflow},
+		{"element", []int16{1, 1000}, new([]int8), ErrOverflow},
+		{"map value", map[string]int{"a": 1 << 20}, new(map[string]int16), ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, enc := range []*Encoder{NewEncoder(nil), NewEncoderWithOptions(nil, WithCompactInts(), WithPackedArrays())} {
+				b, err := enc.Encode(tt.val)
+				assert.NoError(t, err)
+
+				_, err = Decode(b, tt.out)
+				if tt.err == nil {
+					assert.NoError(t, err)
+					continue
+				}
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			}
+		})
+	}
+
+	b, err := Encode(300)
+	assert.NoError(t, err)
+	var x int64
+	_, err = decoder.DecodeInt(b, &x)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), x)
+
+	b, err = Encode(-1)
+	assert.NoError(t, err)
+	var u uint64
+	_, err = decoder.DecodeUint(b, &u)
+	assert.True(t, errors.Is(err, ErrOverflow), "got %v", err)
+
+	b, err = Encode(uint64(math.MaxUint64))
+	assert.NoError(t, err)
+	_, err = decoder.DecodeInt(b, &x)
+	assert.True(t, errors.Is(err, ErrOverflow), "got %v", err)
+}
+
+// TestDecoder_DecodeHugeHeader checks uint32 and VarLen headers whose count
+// does not fit an int on 32-bit platforms, where it must not turn negative.
+func TestDecoder_DecodeHugeHeader(t *testing.T) {
+	huge := func(typ Type, rest ...byte) []byte {
+		return append([]byte{byte(typ), 0xFF, 0xFF, 0xFF, 0xFF}, rest...)
+	}
+	// the uvarint of math.MaxUint32, the largest count a VarLen may carry
+	varHuge := func(prefix ...byte) []byte {
+		return append(prefix, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F, 1, 2, 3)
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		val  interface{}
+		err  error
+	}{
+		{"bytes", huge(Bytes, 1, 2, 3), new(interface{}), ErrBufTooSmall},
+		{"bytes typed", huge(Bytes, 1, 2, 3), new([]byte), ErrBufTooSmall},
+		{"map", huge(Map, 1, 2, 3), new(interface{}), ErrCorrupt},
+		{"struct", huge(Struct, 1, 2, 3), new(interface{}), ErrCorrupt},
+		{"varlen array", varHuge(byte(VarLen), byte(ArrayInt)), new([]int), ErrCorrupt},
+		{"varlen array interface", varHuge(byte(VarLen), byte(Array)), new(interface{}), ErrCorrupt},
+		{"varlen bytes", varHuge(byte(VarLen), byte(Bytes)), new(interface{}), ErrBufTooSmall},
+		{"varlen map", varHuge(byte(VarLen), byte(Map)), new(interface{}), ErrCorrupt},
+		{"varlen struct", varHuge(byte(VarLen), byte(Struct)), new(interface{}), ErrCorrupt},
+		{"packed", varHuge(byte(Packed), byte(ArrayInt8)), new([]int8), ErrBufTooSmall},
+		{"bits", varHuge(byte(BitArray)), new([]bool), ErrBufTooSmall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.b, tt.val)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+
+			_, err = decoder.skip(tt.b)
+			assert.Error(t, err)
+
+			err = NewDecoder(bytes.NewReader(tt.b)).DecodeNext(tt.val)
+			assert.Error(t, err)
+		})
+	}
+
+	var data []byte
+	_, err := decoder.DecodeBytes(huge(Bytes, 1, 2, 3), &data)
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+	_, _, err = decoder.DecodeStructHeader(huge(Struct, 1, 2, 3))
+	assert.True(t, errors.Is(err, ErrCorrupt), "got %v", err)
+}
+
+func FuzzDecode(f *testing.F) {
+	type testStruct struct {
+		Name  string
+		Price float64
+		Tags  []string
+		Attrs map[string]int
+	}
+
+	seeds := []interface{}{
+		uint8(1), int64(-4), "hello", []byte("raw"), time.Second,
+		[]int{1, 2}, []string{"a", "b"}, map[string]interface{}{"a": 1, "b": []float64{1.5}},
+		testStruct{Name: "apple", Price: 1.5, Tags: []string{"red"}, Attrs: map[string]int{"a": 1}},
+	}
+	for _, s := range seeds {
+		b, err := Encode(s)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var v interface{}
+		if n, err := Decode(b, &v); err == nil && n > len(b) {
+			t.Fatalf("consumed %d of %d bytes", n, len(b))
+		}
+
+		var s testStruct
+		Decode(b, &s)
+
+		if n, err := decoder.skip(b); err == nil && n > len(b) {
+			t.Fatalf("skipped %d of %d bytes", n, len(b))
+		}
+
+		NewDecoder(bytes.NewReader(b)).DecodeNext(&v)
+	})
+}
+
+func TestDecoder_DecodeConsumed(t *testing.T) {
+	x := 5
+	values := append([]interface{}{
+		&x,
+		[3]int{1, 2, 3},
+		[2]bool{true, false},
+		[2]string{"a", "b"},
+		money{1, 50},
+		level(3),
+		id{1, 2},
+		[]money{{1, 0}},
+		map[level]id{1: {3, 4}},
+		struct {
+			P, Q *int
+			A    [2]uint8
+			M    money
+			I    interface{}
+		}{P: &x, A: [2]uint8{1, 2}, I: []interface{}{map[string]interface{}{"a": 1}}},
+	}, skipValues...)
+
+	for name, opts := range optionSets {
+		t.Run(name, func(t *testing.T) {
+			enc := NewEncoderWithOptions(nil, opts...)
+			dec := NewDecoderWithOptions(nil, opts...)
+			for _, v := range values {
+				b, err := enc.Encode(v)
+				assert.NoError(t, err)
+				// values following in the buffer must not be consumed
+				tail := append(b, byte(Int8), 1)
+
+				var i interface{}
+				n, err := dec.Decode(tail, &i)
+				assert.NoError(t, err, "%T", v)
+				assert.Equal(t, len(b), n, "%T into interface", v)
+
+				stream := NewDecoderWithOptions(bytes.NewReader(tail), opts...)
+				assert.NoError(t, stream.DecodeNext(new(interface{})), "%T from stream", v)
+				assert.NoError(t, stream.DecodeNext(new(interface{})), "%T from stream", v)
+
+				if v == nil {
+					continue
+				}
+				p := reflect.New(reflect.TypeOf(v))
+				n, err = dec.Decode(tail, p.Interface())
+				assert.NoError(t, err, "%T", v)
+				assert.Equal(t, len(b), n, "%T", v)
+			}
+		})
+	}
+}
+
+type errItem struct {
+	SKU   string
+	Price float64
+}
+
+type errOrder struct {
+	ID    int
+	Items []errItem
+	Attrs map[string]int
+}
+
+func TestDecoder_DecodeError(t *testing.T) {
+	type wireItem struct {
+		SKU   string
+		Price interface{}
+	}
+
+	type wireOrder struct {
+		ID    int
+		Items []wireItem
+		Attrs map[string]interface{}
+	}
+
+	bad := append([]byte{byte(String)}, "bad\x00"...)
+	tests := []struct {
+		name     string
+		in       interface{}
+		out      interface{}
+		path     string
+		expected string
+		err      error
+	}{
+		{
+			"struct field",
+			wireOrder{Items: []wireItem{{"a", 1.0}, {"b", 2.0}, {"c", 3.0}, {"d", "bad"}}},
+			new(errOrder),
+			"errOrder.Items[3].Price",
+			"float64",
+			ErrTypeMismatch,
+		},
+		{
+			"map value",
+			wireOrder{Attrs: map[string]interface{}{"k": "bad"}},
+			new(errOrder),
+			"errOrder.Attrs[k]",
+			"int",
+			ErrTypeMismatch,
+		},
+		{
+			"unnamed root",
+			[]interface{}{1, "bad"},
+			new([]int),
+			"[1]",
+			"int",
+			ErrTypeMismatch,
+		},
+		{
+			"unknown field",
+			struct{ Extra string }{"bad"},
+			new(errItem),
+			"errItem.Extra",
+			"",
+			ErrUnknownField,
+		},
+	}
+
+	strict := NewDecoderWithOptions(nil, WithDisallowUnknownFields())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(tt.in)
+			assert.NoError(t, err)
+
+			_, err = strict.Decode(b, tt.out)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+
+			var de *DecodeError
+			if assert.True(t, errors.As(err, &de)) {
+				assert.Equal(t, tt.path, de.Path)
+				assert.Equal(t, tt.expected, de.Expected)
+				assert.Equal(t, bytes.Index(b, bad), de.Offset)
+				assert.Equal(t, String, de.Type)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeErrorTruncated(t *testing.T) {
+	in := errOrder{ID: 1, Items: []errItem{{"a", 1}, {"b", 2}}, Attrs: map[string]int{"k": 1}}
+	b, err := Encode(in)
+	assert.NoError(t, err)
+
+	for i := 0; i < len(b); i++ {
+		var out errOrder
+		_, err := Decode(b[:i], &out)
+
+		var de *DecodeError
+		if assert.True(t, errors.As(err, &de), "truncated to %d bytes: %v", i, err) {
+			assert.True(t, de.Offset <= i, "offset %d past %d bytes", de.Offset, i)
+			assert.NotNil(t, de.Err)
+		}
+	}
+
+	// the last value is the Int of Attrs["k"]
+	_, err = Decode(b[:len(b)-1], new(errOrder))
+	want := fmt.Sprintf("binary: decoding errOrder.Attrs[k] at offset %d, found Int, expected int: buffer is too small", len(b)-9)
+	assert.Equal(t, want, err.Error())
+}
+
+func TestDecoder_DecodeArrayTyped(t *testing.T) {
+	b, err := Encode([]interface{}{1, 2, 3})
+	assert.NoError(t, err)
+
+	var is []int
+	n, err := Decode(b, &is)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, []int{1, 2, 3}, is)
+
+	b, err = Encode([]interface{}{[]interface{}{"a", uint8(1)}, []string{"b"}})
+	assert.NoError(t, err)
+
+	var nested []interface{}
+	_, err = Decode(b, &nested)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{"a", uint8(1)}, []string{"b"}}, nested)
+}
+
+func TestDecoder_DecodeLongArray(t *testing.T) {
+	in := make([]int, 70000)
+	for i := range in {
+		in[i] = i
+	}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(VarLen), byte(ArrayInt), 0xF0, 0xA2, 0x04}, b[:5])
+
+	var out []int
+	n, err := Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, in, out)
+
+	m, err := decoder.skip(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), m)
+
+	var next []int
+	assert.NoError(t, NewDecoder(bytes.NewReader(b)).DecodeNext(&next))
+	assert.Equal(t, in, next)
+
+	short, err := Encode(make([]bool, 0xFFFF))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(ArrayBool), 0xFF, 0xFF}, short[:3])
+}
+
+func TestDecoder_DecodeLongArrayOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"varint overflow", []byte{byte(VarLen), byte(ArrayInt), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, ErrCorrupt},
+		{"over uint32", []byte{byte(VarLen), byte(ArrayInt), 0x80, 0x80, 0x80, 0x80, 0x10}, ErrCorrupt},
+		{"count exceeds input", []byte{byte(VarLen), byte(ArrayInt), 0x80, 0x80, 0x04, byte(Int)}, ErrCorrupt},
+		{"truncated count", []byte{byte(VarLen), byte(ArrayInt), 0x80}, ErrBufTooSmall},
+		{"not an array", []byte{byte(VarLen), byte(String), 0x01}, ErrInvalidCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			_, err := Decode(tt.b, &v)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestDecoder_DecodeVarStrings(t *testing.T) {
+	type record struct {
+		Name string
+		Body string `binary:"bo\x00dy"`
+		Tags []string
+	}
+
+	in := record{Name: "a\x00b", Body: "\x00\x00", Tags: []string{"x\x00", ""}}
+	for _, enc := range []*Encoder{encoder, NewEncoderWithOptions(nil, WithVarStrings())} {
+		b, err := enc.Encode(in)
+		assert.NoError(t, err)
+
+		var out record
+		n, err := Decode(b, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), n)
+		assert.Equal(t, in, out)
+
+		m, err := decoder.skip(b)
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), m)
+
+		var next record
+		assert.NoError(t, NewDecoder(bytes.NewReader(b)).DecodeNext(&next))
+		assert.Equal(t, in, next)
+	}
+
+	_, err := Decode([]byte{byte(VarString), 5, 'a'}, new(string))
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+}
+
+func TestDecoder_DecodePointers(t *testing.T) {
+	type inner struct {
+		N int
+	}
+
+	type record struct {
+		Name  *string
+		Inner *inner
+		Next  *record
+		Tags  []string
+		Attrs map[string]*int
+		Any   interface{}
+	}
+
+	name, one := "a", 1
+	tests := []struct {
+		name string
+		in   record
+	}{
+		{"empty", record{}},
+		{"set", record{
+			Name:  &name,
+			Inner: &inner{N: 2},
+			Next:  &record{Tags: []string{"x"}},
+			Tags:  []string{},
+			Attrs: map[string]*int{"one": &one, "none": nil},
+			Any:   "any",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(&tt.in)
+			assert.NoError(t, err)
+
+			out := record{Name: new(string), Tags: []string{"stale"}, Any: 1}
+			n, err := Decode(b, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.in, out)
+		})
+	}
+}
+
+func TestDecoder_DecodeNil(t *testing.T) {
+	b, err := Encode(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(Nil)}, b)
+
+	for _, v := range []interface{}{(*int)(nil), []int(nil), map[string]int(nil), []byte(nil)} {
+		b, err := Encode(v)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(Nil)}, b)
+	}
+
+	p := new(int)
+	_, err = Decode([]byte{byte(Nil)}, &p)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	var pp **int
+	_, err = Decode([]byte{byte(Int), 5, 0, 0, 0, 0, 0, 0, 0}, &pp)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, **pp)
+
+	got, err := DecodeTo([]byte{byte(Nil)})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestDecoder_DecodeStructCollections(t *testing.T) {
+	type item struct {
+		SKU   string
+		Count int
+	}
+
+	type order struct {
+		ID    uint32
+		Items []item
+	}
+
+	type customer struct {
+		Name   string
+		Orders []order
+	}
+
+	type orders []order
+
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+	}{
+		{"slice", []order{{ID: 1, Items: []item{{"a", 1}}}, {ID: 2}}, new([]order)},
+		{"named slice", orders{{ID: 3, Items: []item{{"b", 2}, {"c", 3}}}}, new(orders)},
+		{"array", [2]item{{"a", 1}, {"b", 2}}, new([2]item)},
+		{"ints array", [3]int{1, 2, 3}, new([3]int)},
+		{"map", map[string]customer{
+			"alice": {Name: "Alice", Orders: []order{{ID: 1}}},
+			"bob":   {Name: "Bob"},
+		}, new(map[string]customer)},
+		{"nested", map[string][]map[string]item{"x": {{"y": {"z", 1}}}}, new(map[string][]map[string]item)},
+		{"slices of slices", [][]item{{{"a", 1}}, {}}, new([][]item)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(tt.val)
+			assert.NoError(t, err)
+
+			n, err := Decode(b, tt.out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.val, reflect.ValueOf(tt.out).Elem().Interface())
+		})
+	}
+
+	type names []string
+	b, err := Encode(names{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(ArrayString), b[0])
+
+	_, err = Decode(b, new([0]string))
+	assert.True(t, errors.Is(err, ErrTypeMismatch), "got %v", err)
+}
+
+func TestDecoder_DecodeTypedArrays(t *testing.T) {
+	now := time.Date(2021, 4, 23, 10, 0, 0, 5, time.UTC)
+	tests := []struct {
+		typ Type
+		val interface{}
+	}{
+		{ArrayInt8, []int8{-128, 0, 127}},
+		{ArrayInt16, []int16{-32768, 1, 32767}},
+		{ArrayInt32, []int32{-1, 1 << 30}},
+		{ArrayInt64, []int64{-1 << 62, 42}},
+		{ArrayUint16, []uint16{0, 65535}},
+		{ArrayUint32, []uint32{1, 1 << 31}},
+		{ArrayUint64, []uint64{1 << 63, 0}},
+		{ArrayTimestamp, []time.Time{now, now.Add(time.Hour)}},
+		{ArrayDuration, []time.Duration{time.Millisecond, -time.Hour}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			b, err := Encode(tt.val)
+			assert.NoError(t, err)
+			assert.Equal(t, byte(tt.typ), b[0])
+
+			out := reflect.New(reflect.TypeOf(tt.val))
+			n, err := Decode(b, out.Interface())
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.val, out.Elem().Interface())
+
+			got, err := DecodeTo(b)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.val, got)
+		})
+	}
+}
+
+func TestDecoder_DecodePacked(t *testing.T) {
+	now := time.Date(2021, 4, 23, 10, 0, 0, 5, time.UTC)
+	enc := NewEncoderWithOptions(nil, WithPackedArrays())
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+		want interface{}
+	}{
+		{"ints", []int{1, -2, 3}, new([]int), nil},
+		{"uints", []uint{1, 2}, new([]uint), nil},
+		{"int8s", []int8{-1, 1}, new([]int8), nil},
+		{"int16s", []int16{-1, 1}, new([]int16), nil},
+		{"int32s", []int32{-1, 1}, new([]int32), nil},
+		{"int64s", []int64{-1, 1}, new([]int64), nil},
+		{"uint16s", []uint16{1, 2}, new([]uint16), nil},
+		{"uint32s", []uint32{1, 2}, new([]uint32), nil},
+		{"uint64s", []uint64{1, 2}, new([]uint64), nil},
+		{"float32s", []float32{1.5, 2.5}, new([]float32), nil},
+		{"float64s", []float64{1.5, 2.5}, new([]float64), nil},
+		{"bools", []bool{true, false}, new([]bool), nil},
+		{"timestamps", []time.Time{now}, new([]time.Time), nil},
+		{"durations", []time.Duration{time.Second}, new([]time.Duration), nil},
+		{"widened", []int16{-1, 1}, new([]int64), []int64{-1, 1}},
+		{"array", []int32{1, 2}, new([2]int32), [2]int32{1, 2}},
+		{"interface", []float64{0.5}, new(interface{}), nil},
+		{"struct", struct{ Samples []uint16 }{[]uint16{1, 2, 3}}, new(struct{ Samples []uint16 }), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.want == nil {
+				tt.want = tt.val
+			}
+
+			b, err := enc.Encode(tt.val)
+			assert.NoError(t, err)
+
+			n, err := Decode(b, tt.out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.want, reflect.ValueOf(tt.out).Elem().Interface())
+
+			m, err := decoder.skip(b)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), m)
+
+			next := reflect.New(reflect.TypeOf(tt.out).Elem())
+			assert.NoError(t, NewDecoder(bytes.NewReader(b)).DecodeNext(next.Interface()))
+			assert.Equal(t, tt.want, next.Elem().Interface())
+		})
+	}
+
+	_, err := Decode([]byte{byte(Packed), byte(ArrayInt32), 2, 1, 0, 0, 0}, new([]int32))
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+	_, err = Decode([]byte{byte(Packed), byte(ArrayString), 0}, new([]string))
+	assert.True(t, errors.Is(err, ErrInvalidCodec), "got %v", err)
+}
+
+func TestDecoder_DecodeBitArray(t *testing.T) {
+	type flags struct {
+		Name  string
+		Flags []bool
+	}
+
+	long := make([]bool, 70000)
+	for i := range long {
+		long[i] = i%3 == 0
+	}
+	yes := true
+
+	enc := NewEncoderWithOptions(nil, WithBitPackedBools())
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+		want interface{}
+	}{
+		{"empty", []bool{}, new([]bool), nil},
+		{"bools", []bool{true, false, true, true, false, false, true, false, true}, new([]bool), nil},
+		{"long", long, new([]bool), nil},
+		{"array", []bool{true, true}, new([3]bool), [3]bool{true, true, false}},
+		{"interface", []bool{false, true}, new(interface{}), nil},
+		{"interfaces", []bool{false, true, true}, new([]interface{}), []interface{}{false, true, true}},
+		{"pointers", []bool{true}, new([]*bool), []*bool{&yes}},
+		{"struct", flags{"f", []bool{true, false, true}}, new(flags), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.want == nil {
+				tt.want = tt.val
+			}
+
+			b, err := enc.Encode(tt.val)
+			assert.NoError(t, err)
+
+			n, err := Decode(b, tt.out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.want, reflect.ValueOf(tt.out).Elem().Interface())
+
+			m, err := decoder.skip(b)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), m)
+
+			next := reflect.New(reflect.TypeOf(tt.out).Elem())
+			assert.NoError(t, NewDecoder(bytes.NewReader(b)).DecodeNext(next.Interface()))
+			assert.Equal(t, tt.want, next.Elem().Interface())
+		})
+	}
+
+	_, err := Decode([]byte{byte(BitArray), 9, 0xFF}, new([]bool))
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+	_, err = Decode([]byte{byte(BitArray), 3, 0x01}, new([2]bool))
+	assert.True(t, errors.Is(err, ErrTypeMismatch), "got %v", err)
+	_, err = Decode([]byte{byte(BitArray), 1, 0x01}, new([]int))
+	assert.True(t, errors.Is(err, ErrTypeMismatch), "got %v", err)
+	var de *DecodeError
+	if assert.True(t, errors.As(err, &de), "got %T", err) {
+		assert.Equal(t, "[0]", de.Path)
+	}
+}
+
+func TestDecoder_DecodeCompactInts(t *testing.T) {
+	type sample struct {
+		Name   string
+		Count  uint64
+		Delta  int
+		Values []int64
+		Data   []byte
+		Attrs  map[string]uint
+	}
+
+	enc := NewEncoderWithOptions(nil, WithCompactInts())
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+		want interface{}
+	}{
+		{"int", -12345, new(int), nil},
+		{"int64 min", int64(math.MinInt64), new(int64), nil},
+		{"uint64 max", uint64(math.MaxUint64), new(uint64), nil},
+		{"narrowed", 7, new(int8), int8(7)},
+		{"interface int", 7, new(interface{}), int64(7)},
+		{"interface uint", uint(7), new(interface{}), uint64(7)},
+		{"bytes", []byte{1, 2, 3}, new([]byte), nil},
+		{"ints", []int{1, -2, 3}, new([]int), nil},
+		{"map", map[int]string{1: "a", -1: "b"}, new(map[int]string), nil},
+		{"struct", sample{"s", 1, -1, []int64{1, 2}, []byte("x"), map[string]uint{"a": 1}}, new(sample), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.want == nil {
+				tt.want = tt.val
+			}
+
+			b, err := enc.Encode(tt.val)
+			assert.NoError(t, err)
+
+			n, err := Decode(b, tt.out)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, tt.want, reflect.ValueOf(tt.out).Elem().Interface())
+
+			m, err := decoder.skip(b)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), m)
+
+			next := reflect.New(reflect.TypeOf(tt.out).Elem())
+			assert.NoError(t, NewDecoder(bytes.NewReader(b)).DecodeNext(next.Interface()))
+			assert.Equal(t, tt.want, next.Elem().Interface())
+		})
+	}
+
+	small, err := enc.Encode(sample{Count: 1, Values: []int64{1}})
+	assert.NoError(t, err)
+	large, err := Encode(sample{Count: 1, Values: []int64{1}})
+	assert.NoError(t, err)
+	assert.Less(t, len(small), len(large))
+
+	_, err = Decode([]byte{byte(Varint), 0x80}, new(int))
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+	_, err = Decode([]byte{byte(Uvarint), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, new(uint))
+	assert.True(t, errors.Is(err, ErrCorrupt), "got %v", err)
+	_, err = Decode([]byte{byte(VarLen), byte(Bytes), 3, 1}, new([]byte))
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+	_, err = Decode([]byte{byte(VarLen), byte(Map), 0x80, 0x80, 0x04}, new(map[int]int))
+	assert.True(t, errors.Is(err, ErrCorrupt), "got %v", err)
+}
@@ -3,13 +3,17 @@ package binary
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"io"
+	"math"
 	"reflect"
+	"strings"
 	"time"
 )
 
 var (
-	encoder   = &Encoder{}
+	encoder = &Encoder{}
+	// ByteOrder is the byte order of Encoders and Decoders created without
+	// WithByteOrder, including the package level functions.
 	ByteOrder = binary.LittleEndian
 )
 
@@ -50,245 +54,347 @@ const (
 	MapValue
 	ElementValue
 	ElementRef
+	VarLen
+	VarString
+	VarStructField
+	Nil
+	ArrayInt8
+	ArrayInt16
+	ArrayInt32
+	ArrayInt64
+	ArrayUint16
+	ArrayUint32
+	ArrayUint64
+	ArrayTimestamp
+	ArrayDuration
+	Packed
+	BitArray
+	Varint
+	Uvarint
 )
 
+// maxArrayLen is the largest element count an array header may carry.
+const maxArrayLen = math.MaxUint32
+
 type Encoder struct {
+	w    io.Writer
+	opts options
+	st   *encodeState
+}
+
+// encodeState is the state of a single call to Encode, shared by the nested
+// values it encodes.
+type encodeState struct {
+	Encoder
+	ptrLevel int                      // pointers, maps and slices being encoded
+	ptrSeen  map[interface{}]struct{} // those of them past the cycle depth
+}
+
+// begin returns the Encoder that encodes a single value for enc, like
+// Decoder.begin does for decoding.
+func (enc *Encoder) begin() *Encoder {
+	if enc.st != nil {
+		return enc
+	}
+	st := &encodeState{Encoder: *enc}
+	st.Encoder.st = st
+	return &st.Encoder
+}
+
+// NewEncoder returns an Encoder that writes each encoded value to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return NewEncoderWithOptions(w)
+}
+
+// NewEncoderWithOptions returns an Encoder configured by opts. When w is not
+// nil every value passed to Encode is also written to it.
+func NewEncoderWithOptions(w io.Writer, opts ...Option) *Encoder {
+	return &Encoder{w: w, opts: newOptions(opts)}
 }
 
+// Encode returns the encoding of val. When the Encoder was created with
+// NewEncoder the encoding is also written to the underlying writer. Map
+// entries are written in the order of their encoded keys, so equal values
+// always encode to the same bytes.
 func (enc *Encoder) Encode(val interface{}) ([]byte, error) {
-	switch x := val.(type) {
-	case uint8, uint16, uint32, uint64, int8, int16, int32,
-		int64, int, uint, float32, float64, bool, string, time.Time, time.Duration:
-		return enc.encode(x)
-	case []byte:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Bytes))
-		binary.Write(&buf, ByteOrder, uint32(len(x)))
-		buf.Write(x)
-		return buf.Bytes(), nil
-	case []int:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayInt))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []uint:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayUint))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []float32:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayFloat32))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []float64:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayFloat))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []bool:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayBool))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []string:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(ArrayString))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	case []interface{}:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Array))
-		binary.Write(&buf, ByteOrder, uint16(len(x)))
-		for _, m := range x {
-			mb, err := enc.encode(m)
-			if err != nil {
-				return nil, err
-			}
-			buf.Write(mb)
-		}
-		return buf.Bytes(), nil
-	default:
-		v := reflect.ValueOf(x)
-		switch v.Kind() {
-		case reflect.Struct:
-			var buf bytes.Buffer
-			buf.WriteByte(byte(Struct))
-			binary.Write(&buf, ByteOrder, uint32(v.NumField()))
-			t := v.Type()
-			for i := 0; i < t.NumField(); i++ {
-				var (
-					ft = t.Field(i)
-					fv = v.Field(i)
-				)
-				buf.WriteByte(byte(StructField))
-				buf.WriteString(ft.Name)
-				buf.WriteByte(0)
-				fb, err := enc.encode(fv.Interface())
-				if err != nil {
-					return nil, err
-				}
-				buf.WriteByte(byte(StructValue))
-				buf.Write(fb)
-			}
-			return buf.Bytes(), nil
-		case reflect.Map:
-			var buf bytes.Buffer
-			buf.WriteByte(byte(Map))
-			binary.Write(&buf, ByteOrder, uint32(len(v.MapKeys())))
-			iter := v.MapRange()
-			for iter.Next() {
-				mk := iter.Key()
-				mv := iter.Value()
-				buf.WriteByte(byte(MapKey))
-				kb, err := enc.encode(mk.Interface())
-				if err != nil {
-					return nil, err
-				}
-				buf.Write(kb)
-				buf.WriteByte(byte(MapValue))
-				vb, err := enc.encode(mv.Interface())
-				if err != nil {
-					return nil, err
-				}
-				buf.Write(vb)
-			}
-			return buf.Bytes(), nil
-		}
+	b, err := enc.encodeValue(val)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, errors.New("invalid type")
+	if enc.w != nil {
+		if _, err := enc.w.Write(b); err != nil {
+			return nil, err
+		}
 	}
+	return b, nil
 }
 
-func (enc *Encoder) encode(val interface{}) ([]byte, error) {
-	var b = make([]byte, 9)
+// AppendEncode appends the encoding of val to dst and returns the extended
+// buffer. Unlike Encode it never writes to the underlying writer. Scalars
+// are written straight into dst, so encoding them allocates nothing when dst
+// has enough capacity.
+func (enc *Encoder) AppendEncode(dst []byte, val interface{}) ([]byte, error) {
 	switch x := val.(type) {
 	case uint8:
-		b[0] = uint8(Uint8)
-		b[1] = x
-		return b[:2], nil
+		return enc.appendFixed(dst, Uint8, uint64(x)), nil
 	case uint16:
-		b[0] = uint8(Uint16)
-		ByteOrder.PutUint16(b[1:], x)
-		return b[:3], nil
+		return enc.appendFixed(dst, Uint16, uint64(x)), nil
 	case uint32:
-		b[0] = uint8(Uint32)
-		ByteOrder.PutUint32(b[1:], x)
-		return b[:5], nil
+		return enc.appendFixed(dst, Uint32, uint64(x)), nil
 	case uint64:
-		b[0] = uint8(Uint64)
-		ByteOrder.PutUint64(b[1:], x)
-		return b[:9], nil
+		return enc.appendFixed(dst, Uint64, x), nil
+	case uint:
+		return enc.appendFixed(dst, Uint, uint64(x)), nil
 	case int8:
-		b[0] = uint8(Int8)
-		b[1] = uint8(x)
-		return b[:2], nil
+		return enc.appendFixed(dst, Int8, uint64(x)), nil
 	case int16:
-		b[0] = uint8(Int16)
-		ByteOrder.PutUint16(b[1:], uint16(x))
-		return b[:3], nil
+		return enc.appendFixed(dst, Int16, uint64(x)), nil
 	case int32:
-		b[0] = uint8(Int32)
-		ByteOrder.PutUint32(b[1:], uint32(x))
-		return b[:5], nil
+		return enc.appendFixed(dst, Int32, uint64(x)), nil
 	case int64:
-		b[0] = uint8(Int64)
-		ByteOrder.PutUint64(b[1:], uint64(x))
-		return b[:9], nil
+		return enc.appendFixed(dst, Int64, uint64(x)), nil
 	case int:
-		b[0] = uint8(Int)
-		ByteOrder.PutUint64(b[1:], uint64(x))
-		return b[:9], nil
-	case uint:
-		b[0] = uint8(Uint)
-		ByteOrder.PutUint64(b[1:], uint64(x))
-		return b[:9], nil
+		return enc.appendFixed(dst, Int, uint64(x)), nil
 	case float32:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Float32))
-		if err := binary.Write(&buf, ByteOrder, x); err != nil {
-			return nil, err
-		}
-		return buf.Bytes(), nil
+		return enc.appendFixed(dst, Float32, uint64(math.Float32bits(x))), nil
 	case float64:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Float64))
-		if err := binary.Write(&buf, ByteOrder, x); err != nil {
-			return nil, err
-		}
-		return buf.Bytes(), nil
+		return enc.appendFixed(dst, Float64, math.Float64bits(x)), nil
 	case bool:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Bool))
-		if err := binary.Write(&buf, ByteOrder, x); err != nil {
-			return nil, err
+		var bit uint64
+		if x {
+			bit = 1
 		}
-		return buf.Bytes(), nil
+		return enc.appendFixed(dst, Bool, bit), nil
 	case string:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(String))
-		buf.WriteString(x)
-		buf.WriteByte(0)
-		return buf.Bytes(), nil
-	case time.Time:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Timestamp))
-		if err := binary.Write(&buf, ByteOrder, x.UnixNano()); err != nil {
-			return nil, err
+		return enc.appendString(dst, String, VarString, x), nil
+	case []byte:
+		if x == nil {
+			break
 		}
-		return buf.Bytes(), nil
+		return enc.appendBytes(dst, x)
+	case time.Time:
+		return enc.appendTimestamp(dst, x)
 	case time.Duration:
-		var buf bytes.Buffer
-		buf.WriteByte(byte(Duration))
-		if err := binary.Write(&buf, ByteOrder, int64(x)); err != nil {
+		return enc.appendFixed(dst, Duration, uint64(x)), nil
+	}
+	return enc.appendValue(dst, val)
+}
+
+func (enc *Encoder) encodeValue(val interface{}) ([]byte, error) {
+	return enc.appendValue(nil, val)
+}
+
+// appendValue appends the encoding of val to b.
+func (enc *Encoder) appendValue(b []byte, val interface{}) ([]byte, error) {
+	if val == nil {
+		return append(b, byte(Nil)), nil
+	}
+
+	v := reflect.ValueOf(val)
+	b, err := typeEncoder(v.Type())(enc.begin(), b, v)
+	if err != nil {
+		return nil, encodeRootError(err, v.Type())
+	}
+	return b, nil
+}
+
+// encode returns the encoding of the scalar val.
+func (enc *Encoder) encode(val interface{}) ([]byte, error) {
+	if val == nil {
+		return nil, &UnsupportedValueError{Str: "nil"}
+	}
+
+	v := reflect.ValueOf(val)
+	b, err := scalarEncoder(v.Type())(enc, nil, v)
+	if err != nil {
+		return nil, encodeRootError(err, v.Type())
+	}
+	return b, nil
+}
+
+// appendFixed appends the fixed width value typ whose bits are x, or its
+// Varint or Uvarint form in compact mode.
+func (enc *Encoder) appendFixed(b []byte, typ Type, x uint64) []byte {
+	if enc.opts.compact {
+		switch typ {
+		case Int, Int64:
+			return appendVarint(append(b, byte(Varint)), int64(x))
+		case Uint, Uint64:
+			return appendUvarint(append(b, byte(Uvarint)), x)
+		}
+	}
+	return enc.appendUint(append(b, byte(typ)), fixedSize(typ), x)
+}
+
+// appendUint appends the low n bytes of x in the byte order of enc.
+func (enc *Encoder) appendUint(b []byte, n int, x uint64) []byte {
+	order := enc.opts.order()
+	switch n {
+	case 1:
+		b = append(b, byte(x))
+	case 2:
+		b = append(b, 0, 0)
+		order.PutUint16(b[len(b)-2:], uint16(x))
+	case 4:
+		b = append(b, 0, 0, 0, 0)
+		order.PutUint32(b[len(b)-4:], uint32(x))
+	case 8:
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		order.PutUint64(b[len(b)-8:], x)
+	}
+	return b
+}
+
+// fixedBits returns the bits of the fixed width scalar v as they are written
+// on the wire. Only times can fail, see timeBits.
+func fixedBits(v reflect.Value) (uint64, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32:
+		return uint64(math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return math.Float64bits(v.Float()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return timeBits(v.Interface().(time.Time))
+	}
+}
+
+// Timestamps hold the UnixNano of a time, which is only defined from minTime
+// to maxTime. The zero time, far before minTime, is written as the bits of
+// minTime instead, so minTime itself can not be written.
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// timeBits returns the bits of the Timestamp t, failing for times outside
+// the years 1678 to 2262 other than the zero time.
+func timeBits(t time.Time) (uint64, error) {
+	if t.IsZero() {
+		return uint64(minTime.UnixNano()), nil
+	}
+	if !t.After(minTime) || t.After(maxTime) {
+		return 0, &UnsupportedValueError{Value: reflect.ValueOf(t), Str: t.String(), Err: ErrTimeRange}
+	}
+	return uint64(t.UnixNano()), nil
+}
+
+// appendTimestamp appends t as a Timestamp.
+func (enc *Encoder) appendTimestamp(b []byte, t time.Time) ([]byte, error) {
+	x, err := timeBits(t)
+	if err != nil {
+		return nil, err
+	}
+	return enc.appendFixed(b, Timestamp, x), nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var lb [binary.MaxVarintLen64]byte
+	return append(b, lb[:binary.PutUvarint(lb[:], x)]...)
+}
+
+func appendVarint(b []byte, x int64) []byte {
+	var lb [binary.MaxVarintLen64]byte
+	return append(b, lb[:binary.PutVarint(lb[:], x)]...)
+}
+
+// appendBytes appends data as Bytes.
+func (enc *Encoder) appendBytes(b []byte, data []byte) ([]byte, error) {
+	b, err := enc.appendHeader(b, Bytes, len(data))
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+// appendPacked appends the typed array v as a Packed array: the Packed tag,
+// the array tag, a uvarint element count and the elements without tags.
+func (enc *Encoder) appendPacked(b []byte, typ Type, v reflect.Value) ([]byte, error) {
+	b = append(b, byte(Packed), byte(typ))
+	b = appendUvarint(b, uint64(v.Len()))
+
+	// slices of fixed size numbers are written in bulk
+	if binary.Size(v.Interface()) >= 0 {
+		buf := bytes.NewBuffer(b)
+		if err := binary.Write(buf, enc.opts.order(), v.Interface()); err != nil {
 			return nil, err
 		}
 		return buf.Bytes(), nil
-	default:
-		return nil, errors.New("invalid type")
 	}
+
+	// elements keep their fixed width in compact mode
+	n := fixedSize(arrayElems[typ])
+	for i := 0; i < v.Len(); i++ {
+		x, err := fixedBits(v.Index(i))
+		if err != nil {
+			return nil, encodeError(err, index(i))
+		}
+		b = enc.appendUint(b, n, x)
+	}
+	return b, nil
+}
+
+// appendBits appends the bool slice v as a BitArray: the BitArray tag, a
+// uvarint element count and the values packed eight per byte, least
+// significant bit first.
+func (enc *Encoder) appendBits(b []byte, v reflect.Value) []byte {
+	l := v.Len()
+	b = appendUvarint(append(b, byte(BitArray)), uint64(l))
+	off := len(b)
+	b = append(b, make([]byte, (l+7)/8)...)
+	for i := 0; i < l; i++ {
+		if v.Index(i).Bool() {
+			b[off+i/8] |= 1 << (i % 8)
+		}
+	}
+	return b
+}
+
+// appendHeader appends the tag and count of an array, map or struct, or the
+// tag and length of bytes. Arrays have a uint16 header and the other tags a
+// uint32 header. Counts that do not fit, and all counts in compact mode, are
+// written as a VarLen prefixed uvarint instead.
+func (enc *Encoder) appendHeader(b []byte, typ Type, n int) ([]byte, error) {
+	if !enc.opts.compact {
+		switch typ {
+		case Bytes, Map, Struct:
+			if uint64(n) <= math.MaxUint32 {
+				return enc.appendUint(append(b, byte(typ)), 4, uint64(n)), nil
+			}
+		default:
+			if n <= math.MaxUint16 {
+				return enc.appendUint(append(b, byte(typ)), 2, uint64(n)), nil
+			}
+		}
+	}
+	if uint64(n) > maxArrayLen {
+		return nil, ErrArrayTooLong
+	}
+
+	return appendUvarint(append(b, byte(VarLen), byte(typ)), uint64(n)), nil
+}
+
+// appendString appends s after the tag nul as a NUL terminated string, or
+// after the tag sized with a uvarint length prefix when the Encoder writes
+// var strings or s itself contains a NUL byte.
+func (enc *Encoder) appendString(b []byte, nul, sized Type, s string) []byte {
+	if !enc.opts.varStrings && strings.IndexByte(s, 0) < 0 {
+		b = append(b, byte(nul))
+		b = append(b, s...)
+		return append(b, 0)
+	}
+
+	b = appendUvarint(append(b, byte(sized)), uint64(len(s)))
+	return append(b, s...)
 }
 
 func (enc *Encoder) EncodeIndex(idx int, val interface{}) ([]byte, error) {
@@ -321,6 +427,11 @@ func Encode(val interface{}) ([]byte, error) {
 	return encoder.Encode(val)
 }
 
+// AppendEncode appends the encoding of val to dst with the default Encoder.
+func AppendEncode(dst []byte, val interface{}) ([]byte, error) {
+	return encoder.AppendEncode(dst, val)
+}
+
 func EncodeIndex(idx int, val interface{}) ([]byte, error) {
 	return encoder.EncodeIndex(idx, val)
 }
@@ -1,7 +1,12 @@
 package binary
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -61,6 +66,9 @@ func TestEncoder_Encode(t *testing.T) {
 			0xF4, 0x9A, 0x99, 0x99, 0x99, 0x99, 0x99, 0x0B, 0x40,
 			0xF4, 0x0E, 0x2D, 0xB2, 0x9D, 0xEF, 0x27, 0x1B, 0x40,
 		}, false),
+		testEncode([]int16{1, -1}, ArrayInt16, []byte{0x02, 0, 0xFA, 0x01, 0x00, 0xFA, 0xFF, 0xFF}, false),
+		testEncode([]uint32{7}, ArrayUint32, []byte{0x01, 0, 0xFD, 0x07, 0, 0, 0}, false),
+		testEncode([]time.Duration{time.Second}, ArrayDuration, []byte{0x01, 0, 0xF1, 0x00, 0xCA, 0x9A, 0x3B, 0, 0, 0, 0}, false),
 		testEncode([]string{"hello", "world"}, ArrayString, []byte{0x02, 0x00, 0xF3, 'h', 'e', 'l', 'l', 'o', 0x00, 0xF3, 'w', 'o', 'r', 'l', 'd', 0x00}, false),
 		testEncode(map[string]interface{}{
 			"hello": "world",
@@ -124,3 +132,497 @@ func TestEncoder_encode(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoder_EncodeStructTags(t *testing.T) {
+	type tagged struct {
+		Name    string `binary:"n"`
+		Price   int    `binary:",omitempty"`
+		Note    string `binary:"note,omitempty"`
+		Cache   []byte `binary:"-"`
+		private int
+	}
+
+	tests := []testEnc{
+		testEncode(tagged{Name: "a", Price: 1, Cache: []byte{1}}, Struct, []byte{0x02, 0x00, 0x00, 0x00,
+			0xE5, 'n', 0x00, 0xE4, 0xF3, 'a', 0x00,
+			0xE5, 'P', 'r', 'i', 'c', 'e', 0x00, 0xE4, 0xF7, 0x01, 0, 0, 0, 0, 0, 0, 0,
+		}, false),
+		testEncode(tagged{Name: "b", Note: "x", private: 1}, Struct, []byte{0x02, 0x00, 0x00, 0x00,
+			0xE5, 'n', 0x00, 0xE4, 0xF3, 'b', 0x00,
+			0xE5, 'n', 'o', 't', 'e', 0x00, 0xE4, 0xF3, 'x', 0x00,
+		}, false),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s Encode() = % X, want % X", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoder_EncodeByteOrder(t *testing.T) {
+	type sample struct {
+		A uint16
+		B float32
+		C []int
+	}
+
+	in := sample{A: 0x0102, B: 1.5, C: []int{1, 2}}
+	big := NewEncoderWithOptions(nil, WithByteOrder(binary.BigEndian))
+	got, err := big.Encode(uint16(0x0102))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{byte(Uint16), 0x01, 0x02}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Encoder.Encode() = % X, want % X", got, want)
+	}
+
+	b, err := big.Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out sample
+	dec := NewDecoderWithOptions(nil, WithByteOrder(binary.BigEndian))
+	if _, err := dec.Decode(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Decoder.Decode() = %v, want %v", out, in)
+	}
+
+	little, err := Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(b, little) {
+		t.Errorf("big endian encoding equals little endian encoding % X", b)
+	}
+}
+
+func TestEncoder_EncodeVarStrings(t *testing.T) {
+	enc := NewEncoderWithOptions(nil, WithVarStrings())
+	tests := []struct {
+		name string
+		enc  *Encoder
+		val  interface{}
+		want []byte
+	}{
+		{"nul terminated", encoder, "hi", []byte{byte(String), 'h', 'i', 0}},
+		{"embedded nul", encoder, "a\x00b", []byte{byte(VarString), 3, 'a', 0, 'b'}},
+		{"var", enc, "hi", []byte{byte(VarString), 2, 'h', 'i'}},
+		{"var empty", enc, "", []byte{byte(VarString), 0}},
+		{"var array", enc, []string{"a", ""}, []byte{byte(ArrayString), 2, 0, byte(VarString), 1, 'a', byte(VarString), 0}},
+		{"var struct", enc, struct{ N string }{"x"}, []byte{byte(Struct), 1, 0, 0, 0,
+			byte(VarStructField), 1, 'N', byte(StructValue), byte(VarString), 1, 'x',
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.enc.Encode(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encoder.Encode() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoder_EncodePacked(t *testing.T) {
+	enc := NewEncoderWithOptions(nil, WithPackedArrays())
+	tests := []struct {
+		name string
+		val  interface{}
+		want []byte
+	}{
+		{"ints", []int{1, 2}, []byte{byte(Packed), byte(ArrayInt), 2, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}},
+		{"int16s", []int16{1, -1}, []byte{byte(Packed), byte(ArrayInt16), 2, 0x01, 0x00, 0xFF, 0xFF}},
+		{"bools", []bool{true, false, true}, []byte{byte(Packed), byte(ArrayBool), 3, 1, 0, 1}},
+		{"float32s", []float32{10.3}, []byte{byte(Packed), byte(ArrayFloat32), 1, 205, 204, 36, 65}},
+		{"durations", []time.Duration{time.Second}, []byte{byte(Packed), byte(ArrayDuration), 1, 0x00, 0xCA, 0x9A, 0x3B, 0, 0, 0, 0}},
+		{"empty", []uint64{}, []byte{byte(Packed), byte(ArrayUint64), 0}},
+		{"strings stay tagged", []string{"a"}, []byte{byte(ArrayString), 1, 0, byte(String), 'a', 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enc.Encode(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encoder.Encode() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoder_EncodeBitArray(t *testing.T) {
+	enc := NewEncoderWithOptions(nil, WithBitPackedBools())
+	tests := []struct {
+		name string
+		val  interface{}
+		want []byte
+	}{
+		{"empty", []bool{}, []byte{byte(BitArray), 0}},
+		{"one byte", []bool{true, false, true}, []byte{byte(BitArray), 3, 0x05}},
+		{"two bytes", []bool{true, true, true, true, true, true, true, true, false, true}, []byte{byte(BitArray), 10, 0xFF, 0x02}},
+		{"struct", struct{ Flags []bool }{[]bool{true}}, []byte{byte(Struct), 1, 0, 0, 0,
+			byte(StructField), 'F', 'l', 'a', 'g', 's', 0, byte(StructValue), byte(BitArray), 1, 0x01}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enc.Encode(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encoder.Encode() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoder_EncodeCompactInts(t *testing.T) {
+	enc := NewEncoderWithOptions(nil, WithCompactInts())
+	tests := []struct {
+		name string
+		val  interface{}
+		want []byte
+	}{
+		{"int zero", 0, []byte{byte(Varint), 0}},
+		{"int negative", -1, []byte{byte(Varint), 1}},
+		{"int64", int64(300), []byte{byte(Varint), 0xD8, 0x04}},
+		{"uint", uint(1), []byte{byte(Uvarint), 1}},
+		{"uint64", uint64(300), []byte{byte(Uvarint), 0xAC, 0x02}},
+		{"int32 stays fixed", int32(1), []byte{byte(Int32), 1, 0, 0, 0}},
+		{"uint16 stays fixed", uint16(1), []byte{byte(Uint16), 1, 0}},
+		{"bytes", []byte("ab"), []byte{byte(VarLen), byte(Bytes), 2, 'a', 'b'}},
+		{"ints", []int{1, 2}, []byte{byte(VarLen), byte(ArrayInt), 2, byte(Varint), 2, byte(Varint), 4}},
+		{"map", map[string]uint{"a": 1}, []byte{byte(VarLen), byte(Map), 1,
+			byte(MapKey), byte(String), 'a', 0, byte(MapValue), byte(Uvarint), 1}},
+		{"struct", struct{ N int }{1}, []byte{byte(VarLen), byte(Struct), 1,
+			byte(StructField), 'N', 0, byte(StructValue), byte(Varint), 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enc.Encode(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encoder.Encode() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+
+	packed := NewEncoderWithOptions(nil, WithCompactInts(), WithPackedArrays())
+	got, err := packed.Encode([]int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{byte(Packed), byte(ArrayInt), 1, 1, 0, 0, 0, 0, 0, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Encoder.Encode() = % X, want % X", got, want)
+	}
+}
+
+type encItem struct {
+	SKU  string
+	Hook interface{}
+}
+
+type encOrder struct {
+	Items []encItem
+}
+
+func TestEncoder_EncodeUnsupportedType(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		typ  reflect.Type
+		path string
+	}{
+		{"root", make(chan int), reflect.TypeOf(make(chan int)), ""},
+		{"struct field", encOrder{Items: []encItem{{SKU: "a"}, {SKU: "b", Hook: func() {}}}}, reflect.TypeOf(func() {}), "encOrder.Items[1].Hook"},
+		{"map value", map[string]interface{}{"k": make(chan int)}, reflect.TypeOf(make(chan int)), "[k]"},
+		{"interface field", struct{ Note interface{} }{complex(1, 2)}, reflect.TypeOf(complex(1, 2)), "Note"},
+		{"pointer", &encOrder{Items: []encItem{{Hook: func() {}}}}, reflect.TypeOf(func() {}), "Items[0].Hook"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Encode(tt.val)
+			var ute *UnsupportedTypeError
+			if !errors.As(err, &ute) {
+				t.Fatalf("Encode() error = %v, want *UnsupportedTypeError", err)
+			}
+			if ute.Type != tt.typ || ute.Path != tt.path {
+				t.Errorf("Encode() error = {%v, %q}, want {%v, %q}", ute.Type, ute.Path, tt.typ, tt.path)
+			}
+		})
+	}
+
+	_, err := Encode(encOrder{Items: []encItem{{Hook: func() {}}}})
+	if want := "binary: unsupported type func() at encOrder.Items[0].Hook"; err == nil || err.Error() != want {
+		t.Errorf("Encode() error = %v, want %s", err, want)
+	}
+}
+
+func TestEncoder_EncodeUnsupportedValue(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		path string
+	}{
+		{"NaN key", map[float64]int{math.NaN(): 1}, ""},
+		{"NaN interface key", map[interface{}]int{math.NaN(): 1}, ""},
+		{"nil key", map[interface{}]int{nil: 1}, ""},
+		{"nil pointer key", map[*money]int{nil: 1}, ""},
+		{"nested", encOrderMap{"a": {float32(math.NaN()): "x"}}, "encOrderMap[a]"},
+		{"early time", time.Date(1677, 1, 1, 0, 0, 0, 0, time.UTC), "Time"},
+		{"late time", encEvent{At: time.Date(2263, 1, 1, 0, 0, 0, 0, time.UTC)}, "encEvent.At"},
+		{"first time", time.Unix(0, math.MinInt64), "Time"},
+		{"time array", []time.Time{{}, time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC)}, "[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Encode(tt.val)
+			var uve *UnsupportedValueError
+			if !errors.As(err, &uve) {
+				t.Fatalf("Encode() error = %v, want *UnsupportedValueError", err)
+			}
+			if uve.Path != tt.path {
+				t.Errorf("Encode() path = %q, want %q", uve.Path, tt.path)
+			}
+		})
+	}
+}
+
+type encOrderMap map[string]map[float32]string
+
+func TestEncoder_EncodeMapOrder(t *testing.T) {
+	strs := map[string]int{}
+	ints := map[int]string{}
+	for i := 0; i < 100; i++ {
+		strs[strconv.Itoa(i)] = i
+		ints[i-50] = strconv.Itoa(i)
+	}
+	vals := []interface{}{
+		strs,
+		ints,
+		map[interface{}]bool{"a": true, 1: false, 2.5: true, uint8(3): false},
+		struct{ M map[string]int }{strs},
+	}
+
+	for _, enc := range []*Encoder{NewEncoder(nil), NewEncoderWithOptions(nil, WithCompactInts())} {
+		for _, val := range vals {
+			want, err := enc.Encode(val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 50; i++ {
+				got, err := enc.Encode(val)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("Encode(%T) = % X, want % X", val, got, want)
+				}
+			}
+		}
+	}
+
+	// a copy filled in another order encodes the same
+	rev := map[string]int{}
+	for i := 99; i >= 0; i-- {
+		rev[strconv.Itoa(i)] = i
+	}
+	a, err := Encode(strs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Encode(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Encode() of equal maps differs:\n% X\n% X", a, b)
+	}
+}
+
+type encEvent struct {
+	At time.Time
+}
+
+func TestEncoder_EncodeTimeRange(t *testing.T) {
+	far := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, enc := range []*Encoder{NewEncoder(nil), NewEncoderWithOptions(nil, WithPackedArrays())} {
+		if _, err := enc.AppendEncode(nil, far); !errors.Is(err, ErrTimeRange) {
+			t.Errorf("AppendEncode() error = %v, want %v", err, ErrTimeRange)
+		}
+
+		_, err := enc.Encode([]time.Time{{}, far})
+		var uve *UnsupportedValueError
+		if !errors.As(err, &uve) || uve.Path != "[1]" {
+			t.Errorf("Encode() error = %v, want *UnsupportedValueError at [1]", err)
+		}
+	}
+}
+
+func TestEncoder_EncodeZeroTime(t *testing.T) {
+	vals := []interface{}{
+		time.Time{},
+		encEvent{},
+		[]time.Time{{}, time.Date(2021, 4, 23, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, enc := range []*Encoder{NewEncoder(nil), NewEncoderWithOptions(nil, WithPackedArrays())} {
+		for _, val := range vals {
+			b, err := enc.Encode(val)
+			if err != nil {
+				t.Fatalf("Encode(%v) error = %v", val, err)
+			}
+			out := reflect.New(reflect.TypeOf(val))
+			if _, err := Decode(b, out.Interface()); err != nil {
+				t.Fatalf("Decode(%v) error = %v", val, err)
+			}
+			if !reflect.DeepEqual(out.Elem().Interface(), val) {
+				t.Errorf("Decode() = %v, want %v", out.Elem().Interface(), val)
+			}
+		}
+	}
+
+	b, err := Encode(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeTo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm, ok := out.(time.Time); !ok || !tm.IsZero() {
+		t.Errorf("DecodeTo() = %v, want the zero time", out)
+	}
+}
+
+func TestAppendEncode(t *testing.T) {
+	now := time.Date(2021, 4, 23, 10, 0, 0, 0, time.UTC)
+	vals := []interface{}{
+		uint8(1), uint16(2), uint32(3), uint64(4), uint(5),
+		int8(-1), int16(-2), int32(-3), int64(-4), -5,
+		float32(1.5), 2.5, true, "hello", []byte("bytes"), now, time.Second,
+		[]int{1, 2}, map[string]int{"a": 1}, struct{ A string }{"a"}, nil, []byte(nil),
+	}
+
+	for _, enc := range []*Encoder{NewEncoder(nil), NewEncoderWithOptions(nil, WithCompactInts(), WithVarStrings())} {
+		for _, val := range vals {
+			want, err := enc.Encode(val)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prefix := []byte{0xAA, 0xBB}
+			got, err := enc.AppendEncode(prefix, val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, append(prefix, want...)) {
+				t.Errorf("AppendEncode(%v) = % X, want % X", val, got[2:], want)
+			}
+		}
+	}
+}
+
+func TestAppendEncode_Allocs(t *testing.T) {
+	vals := []interface{}{
+		uint8(1), uint16(2), uint32(3), uint64(4), uint(5),
+		int8(-1), int16(-2), int32(-3), int64(-4), -5,
+		float32(1.5), 2.5, true, "hello", []byte("bytes"),
+		time.Date(2021, 4, 23, 10, 0, 0, 0, time.UTC), time.Second,
+	}
+
+	buf := make([]byte, 0, 64)
+	for _, val := range vals {
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := AppendEncode(buf[:0], val); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("AppendEncode(%T) allocates %v times, want 0", val, allocs)
+		}
+	}
+}
+
+type encCycle struct {
+	Val  int
+	Next *encCycle
+}
+
+func TestEncoder_EncodeCycle(t *testing.T) {
+	ptr := &encCycle{Val: 1}
+	ptr.Next = ptr
+
+	m := map[string]interface{}{}
+	m["m"] = m
+
+	s := []interface{}{nil}
+	s[0] = s
+
+	tests := []struct {
+		name string
+		val  interface{}
+		path string
+	}{
+		{"pointer", ptr, "Next.Next"},
+		{"struct", *ptr, "encCycle.Next.Next"},
+		{"map", m, "[m][m]"},
+		{"slice", s, "[0][0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Encode(tt.val)
+			var uve *UnsupportedValueError
+			if !errors.As(err, &uve) {
+				t.Fatalf("Encode() error = %v, want *UnsupportedValueError", err)
+			}
+			if uve.Str != "encountered a cycle" {
+				t.Errorf("Encode() Str = %q, want %q", uve.Str, "encountered a cycle")
+			}
+			if !strings.HasPrefix(uve.Path, tt.path) {
+				t.Errorf("Encode() path = %.40q..., want prefix %q", uve.Path, tt.path)
+			}
+		})
+	}
+
+	// chains deeper than the cycle detection depth still encode
+	var long *encCycle
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		long = &encCycle{Val: i, Next: long}
+	}
+	b, err := Encode(long)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	var out *encCycle
+	if _, err := Decode(b, &out); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(out, long) {
+		t.Errorf("Decode() did not round trip a chain of %d pointers", 2*startDetectingCyclesAfter)
+	}
+}
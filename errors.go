@@ -1,6 +1,11 @@
 package binary
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
 
 var (
 	ErrBufTooSmall        = errors.New("buffer is too small")
@@ -11,4 +16,166 @@ var (
 	ErrInvalidStructValue = errors.New("invalid struct value Type")
 	ErrMustScalarType     = errors.New("must scalar type")
 	ErrInvalidElementType = errors.New("invalid element type")
+	ErrNoReader           = errors.New("decoder has no reader")
+	ErrInvalidMarshal     = errors.New("marshaler returned invalid encoding")
+	ErrInvalidCodec       = errors.New("invalid codec of decode")
+	ErrCorrupt            = errors.New("corrupt input")
+	ErrTypeMismatch       = errors.New("value does not match decode target type")
+	ErrOverflow           = errors.New("value overflows decode target type")
+	ErrUnsettable         = errors.New("decode to value must can be set")
+	ErrArrayTooLong       = errors.New("array length overflows header")
+	ErrTimeRange          = errors.New("time outside the Timestamp range")
+	ErrUnknownField       = errors.New("unknown struct field")
+	ErrMaxDepth           = errors.New("nesting exceeds maximum depth")
+	ErrMaxElements        = errors.New("element count exceeds maximum")
+	ErrMaxLength          = errors.New("length exceeds maximum")
+	ErrMaxAllocation      = errors.New("allocation exceeds maximum")
 )
+
+// DecodeError describes where a Decoder failed to decode its input. It wraps
+// the error found there, such as ErrBufTooSmall or ErrTypeMismatch.
+type DecodeError struct {
+	Offset   int    // offset in the input of the value or mark that failed
+	Type     Type   // tag at Offset, zero at the end of the input
+	Expected string // what was expected at Offset, such as a Go type or "map key"
+	Path     string // path of the value from the decoded type, such as "Order.Items[3].Price"
+	Err      error
+
+	root int // length of the type name Path starts with
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	b.WriteString("binary: decoding ")
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		b.WriteByte(' ')
+	}
+	fmt.Fprintf(&b, "at offset %d", e.Offset)
+	if e.Type != 0 {
+		fmt.Fprintf(&b, ", found %v", e.Type)
+	}
+	if e.Expected != "" {
+		fmt.Fprintf(&b, ", expected %s", e.Expected)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError returns err, found at offset in b while decoding expected, as
+// a *DecodeError under the path segment seg, such as ".Name" or "[3]". An err
+// that is already a *DecodeError for a value within b is moved to offset and
+// keeps its own path below seg.
+func decodeError(err error, b []byte, offset int, expected, seg string) *DecodeError {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		de = &DecodeError{Expected: expected, Err: err}
+		if offset < len(b) {
+			de.Type = Type(b[offset])
+		}
+	}
+	de.Offset += offset
+
+	if seg != "" {
+		de.Path, de.root = joinPath(seg, de.Path[de.root:]), 0
+	}
+	return de
+}
+
+// rootError returns err, found decoding b into a value of type t, as a
+// *DecodeError whose path starts with the name of t.
+func rootError(err error, b []byte, t reflect.Type) error {
+	de := decodeError(err, b, 0, t.String(), "")
+	de.Path, de.root = rootPath(t, de.Path[de.root:])
+	return de
+}
+
+// UnsupportedTypeError is returned by an Encoder for a value of a type it can
+// not encode, such as a channel, a function or a named scalar type.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+	Path string // path of the value from the encoded type, such as "Order.Items[3].Price"
+
+	root int
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "binary: unsupported type " + e.Type.String() + at(e.Path)
+}
+
+// UnsupportedValueError is returned by an Encoder for a value it can not
+// encode, such as a NaN or nil map key, an array too long for its header or
+// a time outside the Timestamp range.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string // description of the value
+	Path  string // path of the value from the encoded type
+	Err   error  // ErrArrayTooLong or ErrTimeRange for values overflowing their encoding
+
+	root int
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "binary: unsupported value " + e.Str + at(e.Path)
+}
+
+func (e *UnsupportedValueError) Unwrap() error {
+	return e.Err
+}
+
+func at(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
+
+// encodeError returns err with its path placed below the path segment seg
+// when it is an *UnsupportedTypeError or an *UnsupportedValueError.
+func encodeError(err error, seg string) error {
+	switch e := err.(type) {
+	case *UnsupportedTypeError:
+		e.Path, e.root = joinPath(seg, e.Path[e.root:]), 0
+	case *UnsupportedValueError:
+		e.Path, e.root = joinPath(seg, e.Path[e.root:]), 0
+	}
+	return err
+}
+
+// encodeRootError returns err, found encoding a value of type t, with its
+// path starting with the name of t.
+func encodeRootError(err error, t reflect.Type) error {
+	switch e := err.(type) {
+	case *UnsupportedTypeError:
+		e.Path, e.root = rootPath(t, e.Path[e.root:])
+	case *UnsupportedValueError:
+		e.Path, e.root = rootPath(t, e.Path[e.root:])
+	}
+	return err
+}
+
+// joinPath returns the path of a value below the path segment seg, given its
+// path relative to the value at seg.
+func joinPath(seg, path string) string {
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+	return seg + path
+}
+
+// rootPath returns path, relative to a value of type t, prefixed with the
+// name of t when it is a defined type, and the length of that name.
+func rootPath(t reflect.Type, path string) (string, int) {
+	var name string
+	if t.PkgPath() != "" {
+		name = t.Name()
+	}
+	if name == "" {
+		path = strings.TrimPrefix(path, ".")
+	}
+	return name + path, len(name)
+}
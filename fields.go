@@ -0,0 +1,101 @@
+package binary
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field describes how a struct field is written on the wire.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// typeFields returns the encodable fields of the struct type t in
+// declaration order. Unexported fields, UnknownFields and fields tagged
+// `binary:"-"` are skipped, and a `binary:"name,omitempty"` tag renames the
+// field and drops it from the output when it holds its zero value.
+func typeFields(t reflect.Type) []field {
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" || sf.Type == unknownFieldsType {
+			continue
+		}
+
+		tag := sf.Tag.Get("binary")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := parseTag(tag)
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     i,
+			omitEmpty: opts.contains("omitempty"),
+		})
+	}
+	return fields
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedTypeFields is like typeFields but only parses the fields of each
+// type once.
+func cachedTypeFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.IndexByte(tag, ','); idx >= 0 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+	return tag, ""
+}
+
+func (o tagOptions) contains(name string) bool {
+	for s := string(o); s != ""; {
+		var next string
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == name {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if t, ok := v.Interface().(interface{ IsZero() bool }); ok {
+			return t.IsZero()
+		}
+	}
+	return false
+}
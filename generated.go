@@ -0,0 +1,456 @@
+package binary
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// AppendMarshaler is implemented by types that append their own encoding
+// with the options of an Encoder, such as the types generated by
+// cmd/binarygen. Encoders prefer it to Marshaler. The appended bytes must
+// hold exactly one complete encoded value, tag included, which unlike the
+// bytes returned by a Marshaler is not checked.
+type AppendMarshaler interface {
+	AppendBinaryFormat(enc *Encoder, b []byte) ([]byte, error)
+}
+
+// DecodeUnmarshaler is implemented by types that decode themselves with the
+// options of a Decoder, such as the types generated by cmd/binarygen.
+// Decoders prefer it to Unmarshaler. It decodes the value at the start of b
+// and returns the number of bytes it took. Nesting counts against the depth
+// limit of the Decoder through DecodeStructHeader and DecodeArrayHeader.
+type DecodeUnmarshaler interface {
+	DecodeBinaryFormat(dec *Decoder, b []byte) (int, error)
+}
+
+// The methods below encode and decode the parts of a struct without
+// reflection. They are used by the code cmd/binarygen generates and write
+// exactly what Encode writes for the same values.
+
+// AppendStructHeader appends the header of a Struct with n fields.
+func (enc *Encoder) AppendStructHeader(b []byte, n int) ([]byte, error) {
+	return enc.appendHeader(b, Struct, n)
+}
+
+// AppendArrayHeader appends the header of an Array with n elements, which
+// are then appended one by one.
+func (enc *Encoder) AppendArrayHeader(b []byte, n int) ([]byte, error) {
+	b, err := enc.appendHeader(b, Array, n)
+	if err == ErrArrayTooLong {
+		err = &UnsupportedValueError{Str: "of length " + strconv.Itoa(n), Err: err}
+	}
+	return b, err
+}
+
+// EnterPointer records that the value of the non-nil pointer or slice p is
+// being appended, failing with an *UnsupportedValueError when it already is
+// further up because the value refers to itself. Every successful
+// EnterPointer must be followed by a LeavePointer.
+func (enc *Encoder) EnterPointer(p interface{}) error {
+	return enc.enter(reflect.ValueOf(p))
+}
+
+// LeavePointer ends the EnterPointer of p.
+func (enc *Encoder) LeavePointer(p interface{}) {
+	enc.leave(reflect.ValueOf(p))
+}
+
+// AppendFieldName appends the name of a struct field and the StructValue
+// mark that precedes its value.
+func (enc *Encoder) AppendFieldName(b []byte, name string) []byte {
+	return append(enc.appendString(b, StructField, VarStructField, name), byte(StructValue))
+}
+
+// CountUnknownFields returns the number of struct fields held by u, which
+// AppendStructHeader must count in for them to be appended after the known
+// fields.
+func (enc *Encoder) CountUnknownFields(u UnknownFields) (int, error) {
+	return unknownDecoder.countFields(u)
+}
+
+// AppendUnknownFields appends the fields u after the known fields of a
+// struct, in the byte order of enc.
+func (enc *Encoder) AppendUnknownFields(b []byte, u UnknownFields) ([]byte, error) {
+	return enc.appendUnknownFields(b, u)
+}
+
+// AppendFixed appends the fixed width scalar typ whose bits are x, such as
+// the bits of an integer or math.Float64bits of a float. In compact mode Int,
+// Int64, Uint and Uint64 are written as Varints and Uvarints. typ must be a
+// fixed width tag other than Bool and Timestamp.
+func (enc *Encoder) AppendFixed(b []byte, typ Type, x uint64) []byte {
+	return enc.appendFixed(b, typ, x)
+}
+
+// AppendTimestamp appends t as a Timestamp. It fails with an
+// *UnsupportedValueError for times outside the years 1678 to 2262, other than
+// the zero time.
+func (enc *Encoder) AppendTimestamp(b []byte, t time.Time) ([]byte, error) {
+	return enc.appendTimestamp(b, t)
+}
+
+// AppendBool appends x as a Bool.
+func (enc *Encoder) AppendBool(b []byte, x bool) []byte {
+	var bit uint64
+	if x {
+		bit = 1
+	}
+	return enc.appendFixed(b, Bool, bit)
+}
+
+// AppendString appends s as a String.
+func (enc *Encoder) AppendString(b []byte, s string) []byte {
+	return enc.appendString(b, String, VarString, s)
+}
+
+// AppendBytes appends data as Bytes, or as Nil when data is nil.
+func (enc *Encoder) AppendBytes(b []byte, data []byte) ([]byte, error) {
+	if data == nil {
+		return append(b, byte(Nil)), nil
+	}
+	return enc.appendBytes(b, data)
+}
+
+// FieldError returns err, which appending the value of the struct field
+// name failed with, with the path of an *UnsupportedTypeError or
+// *UnsupportedValueError placed below the field.
+func (enc *Encoder) FieldError(err error, name string) error {
+	return encodeError(err, "."+name)
+}
+
+// ElementError is like FieldError for the element i of an array.
+func (enc *Encoder) ElementError(err error, i int) error {
+	return encodeError(err, index(i))
+}
+
+// DecodeStructHeader returns the field count of the Struct at the start of
+// b and the offset of its first field. Every successful DecodeStructHeader
+// must be followed by an EndStruct once the fields are decoded.
+func (dec *Decoder) DecodeStructHeader(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrBufTooSmall
+	}
+	if !hasTag(b, Struct) {
+		return 0, 0, ErrTypeMismatch
+	}
+
+	l, offset, err := dec.header(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := dec.checkElements(l); err != nil {
+		return 0, 0, err
+	}
+	// every field takes at least the two marks, a name and a value
+	if l > uint64(len(b)-offset)/4 {
+		return 0, 0, ErrCorrupt
+	}
+	if err := dec.enter(); err != nil {
+		return 0, 0, err
+	}
+	return int(l), offset, nil
+}
+
+// EndStruct ends the Struct started by DecodeStructHeader.
+func (dec *Decoder) EndStruct() {
+	dec.leave()
+}
+
+// DecodeArrayHeader returns the element count of the array at the start of
+// b and the offset of its first element, for elements that are decoded one
+// by one into a slice whose elements take size bytes, as reported by
+// unsafe.Sizeof, or into a Go array when size is 0. Every successful
+// DecodeArrayHeader must be followed by an EndArray once the elements are
+// decoded.
+func (dec *Decoder) DecodeArrayHeader(b []byte, size uintptr) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrBufTooSmall
+	}
+	typ := Type(b[0])
+	if typ == VarLen && len(b) > 1 {
+		typ = Type(b[1])
+	}
+	if _, ok := arrayTypes[typ]; !ok {
+		return 0, 0, ErrTypeMismatch
+	}
+
+	l, offset, err := dec.header(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := dec.checkElements(l); err != nil {
+		return 0, 0, err
+	}
+	// every element takes at least a tag, which is all of a Nil
+	if l > uint64(len(b)-offset) {
+		return 0, 0, ErrCorrupt
+	}
+	if err := dec.allocate(int(l), size); err != nil {
+		return 0, 0, err
+	}
+	if err := dec.enter(); err != nil {
+		return 0, 0, err
+	}
+	return int(l), offset, nil
+}
+
+// SliceCap returns the capacity to make a slice with for count elements of
+// size bytes decoded from b, which holds them: count, or fewer when their
+// size exceeds the length of b, so that a hostile count costs memory in step
+// with the input. The slice is grown by append as elements are decoded.
+func (dec *Decoder) SliceCap(b []byte, count int, size uintptr) int {
+	return sliceCap(count, size, len(b))
+}
+
+// EndArray ends the array started by DecodeArrayHeader.
+func (dec *Decoder) EndArray() {
+	dec.leave()
+}
+
+// DecodeFieldName returns the name of the struct field at the start of b
+// and the length of the name and of the StructValue mark following it. The
+// name shares the memory of b.
+func (dec *Decoder) DecodeFieldName(b []byte) ([]byte, int, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrBufTooSmall
+	}
+
+	name, n, err := dec.fieldName(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n >= len(b) {
+		return nil, 0, ErrBufTooSmall
+	}
+	if Type(b[n]) != StructValue {
+		return nil, 0, ErrInvalidStructValue
+	}
+	return name, n + 1, nil
+}
+
+// DecodeUnknownField is called for the value at the start of b of a struct
+// field named name that the target struct has no field for. It skips the
+// value and returns its length, or fails with ErrUnknownField when dec was
+// created WithDisallowUnknownFields.
+func (dec *Decoder) DecodeUnknownField(name []byte, b []byte) (int, error) {
+	if dec.opts.strict {
+		return 0, fmt.Errorf("%w %q", ErrUnknownField, name)
+	}
+	return dec.skip(b)
+}
+
+// AppendUnknownField appends the struct field f, name and mark included, to
+// u, converting it from the byte order of dec to that of UnknownFields.
+func (dec *Decoder) AppendUnknownField(u UnknownFields, f []byte) (UnknownFields, error) {
+	return dec.appendUnknownField(u, f)
+}
+
+// FieldError returns err, which decoding the Struct at the start of b failed
+// with at offset, as a *DecodeError. name is the name of the field whose
+// value starts at offset, or nil when the field name at offset failed.
+func (dec *Decoder) FieldError(err error, b []byte, offset int, name []byte) error {
+	if name == nil {
+		return decodeError(err, b, offset, "struct field", "")
+	}
+	return decodeError(err, b, offset, "", "."+string(name))
+}
+
+// Allocate charges n values of size bytes, as reported by unsafe.Sizeof, that
+// are about to be allocated for decoded values, such as a pointee, to the
+// limit set WithMaxAllocation, failing with ErrMaxAllocation when they
+// exceed it.
+func (dec *Decoder) Allocate(n int, size uintptr) error {
+	return dec.allocate(n, size)
+}
+
+// ElementError is like FieldError for the element i of the array at the
+// start of b, whose value starts at offset.
+func (dec *Decoder) ElementError(err error, b []byte, offset int, i int) error {
+	return decodeError(err, b, offset, "", index(i))
+}
+
+// DecodeInt decodes the integer or Duration at the start of b into p,
+// converting between widths and signedness like Decode. It fails with
+// ErrOverflow for unsigned values above math.MaxInt64; narrower targets must
+// check the range of *p themselves. A Nil value leaves p unchanged.
+func (dec *Decoder) DecodeInt(b []byte, p *int64) (int, error) {
+	if isNilValue(b) {
+		return 1, nil
+	}
+	x, signed, n, err := dec.integer(b)
+	if err != nil {
+		return 0, err
+	}
+	if !signed && x > math.MaxInt64 {
+		return 0, ErrOverflow
+	}
+	*p = int64(x)
+	return n, nil
+}
+
+// DecodeUint is like DecodeInt for unsigned integers, failing with
+// ErrOverflow for negative values.
+func (dec *Decoder) DecodeUint(b []byte, p *uint64) (int, error) {
+	if isNilValue(b) {
+		return 1, nil
+	}
+	x, signed, n, err := dec.integer(b)
+	if err != nil {
+		return 0, err
+	}
+	if signed && int64(x) < 0 {
+		return 0, ErrOverflow
+	}
+	*p = x
+	return n, nil
+}
+
+// DecodeFloat decodes the Float32 or Float64 at the start of b into p. A
+// Nil value leaves p unchanged.
+func (dec *Decoder) DecodeFloat(b []byte, p *float64) (int, error) {
+	if isNilValue(b) {
+		return 1, nil
+	}
+	if err := dec.checkFixed(b); err != nil {
+		return 0, err
+	}
+
+	switch Type(b[0]) {
+	case Float32:
+		*p = float64(math.Float32frombits(dec.opts.order().Uint32(b[1:])))
+		return 5, nil
+	case Float64:
+		*p = math.Float64frombits(dec.opts.order().Uint64(b[1:]))
+		return 9, nil
+	}
+	return 0, ErrTypeMismatch
+}
+
+// DecodeBool decodes the Bool at the start of b into p. A Nil value leaves
+// p unchanged.
+func (dec *Decoder) DecodeBool(b []byte, p *bool) (int, error) {
+	if isNilValue(b) {
+		return 1, nil
+	}
+	if err := dec.checkFixed(b); err != nil {
+		return 0, err
+	}
+
+	if Type(b[0]) != Bool {
+		return 0, ErrTypeMismatch
+	}
+	*p = b[1] != 0
+	return 2, nil
+}
+
+// DecodeString decodes the String or VarString at the start of b into p. A
+// Nil value leaves p unchanged.
+func (dec *Decoder) DecodeString(b []byte, p *string) (int, error) {
+	if isNilValue(b) {
+		return 1, nil
+	}
+	if len(b) == 0 {
+		return 0, ErrBufTooSmall
+	}
+
+	s, n, err := dec.str(b)
+	if err != nil {
+		return 0, err
+	}
+	*p = s
+	return n, nil
+}
+
+// DecodeBytes decodes the Bytes at the start of b into p, sharing the memory
+// of b like Decode. A Nil value sets p to nil.
+func (dec *Decoder) DecodeBytes(b []byte, p *[]byte) (int, error) {
+	if isNilValue(b) {
+		*p = nil
+		return 1, nil
+	}
+	if len(b) == 0 {
+		return 0, ErrBufTooSmall
+	}
+	if !hasTag(b, Bytes) {
+		return 0, ErrTypeMismatch
+	}
+
+	l, offset, err := dec.header(b)
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.checkLength(l); err != nil {
+		return 0, err
+	}
+	if l > uint64(len(b)-offset) {
+		return 0, ErrBufTooSmall
+	}
+	end := offset + int(l)
+	*p = b[offset:end]
+	return end, nil
+}
+
+// integer returns the bits of the integer or Duration at the start of b,
+// sign extended for the signed tags, whether its tag is signed and its
+// length.
+func (dec *Decoder) integer(b []byte) (uint64, bool, int, error) {
+	if len(b) == 0 {
+		return 0, false, 0, ErrBufTooSmall
+	}
+	if err := dec.checkFixed(b); err != nil {
+		return 0, false, 0, err
+	}
+
+	order := dec.opts.order()
+	switch Type(b[0]) {
+	case Uint8:
+		return uint64(b[1]), false, 2, nil
+	case Uint16:
+		return uint64(order.Uint16(b[1:])), false, 3, nil
+	case Uint32:
+		return uint64(order.Uint32(b[1:])), false, 5, nil
+	case Uint64, Uint:
+		return order.Uint64(b[1:]), false, 9, nil
+	case Int64, Int, Duration:
+		return order.Uint64(b[1:]), true, 9, nil
+	case Int8:
+		return uint64(int8(b[1])), true, 2, nil
+	case Int16:
+		return uint64(int16(order.Uint16(b[1:]))), true, 3, nil
+	case Int32:
+		return uint64(int32(order.Uint32(b[1:]))), true, 5, nil
+	case Varint:
+		x, n := binary.Varint(b[1:])
+		if err := varintErr(n); err != nil {
+			return 0, false, 0, err
+		}
+		return uint64(x), true, n + 1, nil
+	case Uvarint:
+		x, n := binary.Uvarint(b[1:])
+		if err := varintErr(n); err != nil {
+			return 0, false, 0, err
+		}
+		return x, false, n + 1, nil
+	}
+	return 0, false, 0, ErrTypeMismatch
+}
+
+// checkFixed checks that b holds the whole of the fixed width value at its
+// start, if it has a fixed width.
+func (dec *Decoder) checkFixed(b []byte) error {
+	if len(b) == 0 {
+		return ErrBufTooSmall
+	}
+	if n := fixedSize(Type(b[0])); n > 0 && len(b) < n+1 {
+		return ErrBufTooSmall
+	}
+	return nil
+}
+
+func isNilValue(b []byte) bool {
+	return len(b) > 0 && Type(b[0]) == Nil
+}
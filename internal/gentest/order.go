@@ -0,0 +1,47 @@
+// Package gentest holds struct types with methods generated by binarygen,
+// used to test that they match the reflection based Encoder and Decoder.
+package gentest
+
+import (
+	"time"
+
+	"github.com/hysios/binary"
+)
+
+//go:generate go run ../../cmd/binarygen -type Order,Item,Node
+
+type Order struct {
+	ID       uint64
+	Customer string `binary:"customer"`
+	Items    []Item
+	Total    float64
+	Discount float32 `binary:",omitempty"`
+	Paid     bool    `binary:",omitempty"`
+	Status   uint8   `binary:",omitempty"`
+	Created  time.Time
+	Timeout  time.Duration `binary:",omitempty"`
+	Labels   map[string]string
+	Raw      []byte
+	Ship     *Item `binary:",omitempty"`
+	Boxes    [2]Item
+	Level    int8
+	Note     string `binary:",omitempty"`
+	Internal string `binary:"-"`
+	secret   int
+	Unknown  binary.UnknownFields
+}
+
+type Item struct {
+	SKU   string
+	Price float64
+	Qty   int32 `binary:",omitempty"`
+	Count int
+	Seq   uint16
+}
+
+// Node refers to itself through a pointer and a slice.
+type Node struct {
+	Name     string
+	Next     *Node
+	Children []Node
+}
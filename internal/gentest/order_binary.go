@@ -0,0 +1,464 @@
+// Code generated by "binarygen -type Order,Item,Node"; DO NOT EDIT.
+
+package gentest
+
+import (
+	"math"
+	"time"
+	"unsafe"
+
+	"github.com/hysios/binary"
+)
+
+// MarshalBinaryFormat implements binary.Marshaler.
+func (x Order) MarshalBinaryFormat() ([]byte, error) {
+	return binary.AppendEncode(nil, &x)
+}
+
+// UnmarshalBinaryFormat implements binary.Unmarshaler.
+func (x *Order) UnmarshalBinaryFormat(b []byte) error {
+	_, err := binary.Decode(b, x)
+	return err
+}
+
+// AppendBinaryFormat implements binary.AppendMarshaler.
+func (x Order) AppendBinaryFormat(enc *binary.Encoder, b []byte) ([]byte, error) {
+	n := 9
+	if x.Discount != 0 {
+		n++
+	}
+	if x.Paid {
+		n++
+	}
+	if x.Status != 0 {
+		n++
+	}
+	if x.Timeout != 0 {
+		n++
+	}
+	if x.Ship != nil {
+		n++
+	}
+	if x.Note != "" {
+		n++
+	}
+	u, err := enc.CountUnknownFields(x.Unknown)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = enc.AppendStructHeader(b, n+u); err != nil {
+		return nil, err
+	}
+	b = enc.AppendFieldName(b, "ID")
+	b = enc.AppendFixed(b, binary.Uint64, uint64(x.ID))
+	b = enc.AppendFieldName(b, "customer")
+	b = enc.AppendString(b, x.Customer)
+	b = enc.AppendFieldName(b, "Items")
+	if x.Items == nil {
+		b = append(b, byte(binary.Nil))
+	} else {
+		if err = enc.EnterPointer(x.Items); err != nil {
+			return nil, enc.FieldError(err, "Items")
+		}
+		if b, err = enc.AppendArrayHeader(b, len(x.Items)); err != nil {
+			return nil, enc.FieldError(err, "Items")
+		}
+		for i := range x.Items {
+			if b, err = x.Items[i].AppendBinaryFormat(enc, b); err != nil {
+				return nil, enc.FieldError(enc.ElementError(err, i), "Items")
+			}
+		}
+		enc.LeavePointer(x.Items)
+	}
+	b = enc.AppendFieldName(b, "Total")
+	b = enc.AppendFixed(b, binary.Float64, math.Float64bits(x.Total))
+	if x.Discount != 0 {
+		b = enc.AppendFieldName(b, "Discount")
+		b = enc.AppendFixed(b, binary.Float32, uint64(math.Float32bits(x.Discount)))
+	}
+	if x.Paid {
+		b = enc.AppendFieldName(b, "Paid")
+		b = enc.AppendBool(b, x.Paid)
+	}
+	if x.Status != 0 {
+		b = enc.AppendFieldName(b, "Status")
+		b = enc.AppendFixed(b, binary.Uint8, uint64(x.Status))
+	}
+	b = enc.AppendFieldName(b, "Created")
+	if b, err = enc.AppendTimestamp(b, x.Created); err != nil {
+		return nil, enc.FieldError(err, "Created")
+	}
+	if x.Timeout != 0 {
+		b = enc.AppendFieldName(b, "Timeout")
+		b = enc.AppendFixed(b, binary.Duration, uint64(x.Timeout))
+	}
+	b = enc.AppendFieldName(b, "Labels")
+	if b, err = enc.AppendEncode(b, x.Labels); err != nil {
+		return nil, enc.FieldError(err, "Labels")
+	}
+	b = enc.AppendFieldName(b, "Raw")
+	if b, err = enc.AppendBytes(b, x.Raw); err != nil {
+		return nil, enc.FieldError(err, "Raw")
+	}
+	if x.Ship != nil {
+		b = enc.AppendFieldName(b, "Ship")
+		if err = enc.EnterPointer(x.Ship); err != nil {
+			return nil, enc.FieldError(err, "Ship")
+		}
+		if b, err = x.Ship.AppendBinaryFormat(enc, b); err != nil {
+			return nil, enc.FieldError(err, "Ship")
+		}
+		enc.LeavePointer(x.Ship)
+	}
+	b = enc.AppendFieldName(b, "Boxes")
+	if b, err = enc.AppendArrayHeader(b, len(x.Boxes)); err != nil {
+		return nil, enc.FieldError(err, "Boxes")
+	}
+	for i := range x.Boxes {
+		if b, err = x.Boxes[i].AppendBinaryFormat(enc, b); err != nil {
+			return nil, enc.FieldError(enc.ElementError(err, i), "Boxes")
+		}
+	}
+	b = enc.AppendFieldName(b, "Level")
+	b = enc.AppendFixed(b, binary.Int8, uint64(x.Level))
+	if x.Note != "" {
+		b = enc.AppendFieldName(b, "Note")
+		b = enc.AppendString(b, x.Note)
+	}
+	if b, err = enc.AppendUnknownFields(b, x.Unknown); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DecodeBinaryFormat implements binary.DecodeUnmarshaler.
+func (x *Order) DecodeBinaryFormat(dec *binary.Decoder, b []byte) (int, error) {
+	if len(b) > 0 && b[0] == byte(binary.Nil) {
+		return 1, nil
+	}
+	l, off, err := dec.DecodeStructHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.EndStruct()
+	x.Unknown = nil
+	for i := 0; i < l; i++ {
+		start := off
+		name, n, err := dec.DecodeFieldName(b[off:])
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, nil)
+		}
+		off += n
+		switch string(name) {
+		case "ID":
+			n, err = dec.DecodeUint(b[off:], &x.ID)
+		case "customer":
+			n, err = dec.DecodeString(b[off:], &x.Customer)
+		case "Items":
+			if off < len(b) && b[off] == byte(binary.Nil) {
+				x.Items, n = nil, 1
+				break
+			}
+			var count, pos int
+			if count, pos, err = dec.DecodeArrayHeader(b[off:], unsafe.Sizeof(Item{})); err != nil {
+				break
+			}
+			v := make([]Item, 0, dec.SliceCap(b[off+pos:], count, unsafe.Sizeof(Item{})))
+			for j := 0; j < count && err == nil; j++ {
+				v = append(v, Item{})
+				if n, err = v[j].DecodeBinaryFormat(dec, b[off+pos:]); err != nil {
+					err = dec.ElementError(err, b[off:], pos, j)
+				}
+				pos += n
+			}
+			dec.EndArray()
+			if err == nil {
+				x.Items, n = v, pos
+			}
+		case "Total":
+			n, err = dec.DecodeFloat(b[off:], &x.Total)
+		case "Discount":
+			v := float64(x.Discount)
+			n, err = dec.DecodeFloat(b[off:], &v)
+			x.Discount = float32(v)
+		case "Paid":
+			n, err = dec.DecodeBool(b[off:], &x.Paid)
+		case "Status":
+			v := uint64(x.Status)
+			n, err = dec.DecodeUint(b[off:], &v)
+			if err == nil && uint64(uint8(v)) != v {
+				err = binary.ErrOverflow
+			}
+			x.Status = uint8(v)
+		case "Created":
+			n, err = dec.Decode(b[off:], &x.Created)
+		case "Timeout":
+			v := int64(x.Timeout)
+			n, err = dec.DecodeInt(b[off:], &v)
+			x.Timeout = time.Duration(v)
+		case "Labels":
+			n, err = dec.Decode(b[off:], &x.Labels)
+		case "Raw":
+			n, err = dec.DecodeBytes(b[off:], &x.Raw)
+		case "Ship":
+			if off < len(b) && b[off] == byte(binary.Nil) {
+				x.Ship, n = nil, 1
+				break
+			}
+			if x.Ship == nil {
+				if err = dec.Allocate(1, unsafe.Sizeof(Item{})); err != nil {
+					break
+				}
+				x.Ship = new(Item)
+			}
+			n, err = x.Ship.DecodeBinaryFormat(dec, b[off:])
+		case "Boxes":
+			if off < len(b) && b[off] == byte(binary.Nil) {
+				n = 1
+				break
+			}
+			var count, pos int
+			if count, pos, err = dec.DecodeArrayHeader(b[off:], 0); err != nil {
+				break
+			}
+			var v [2]Item
+			if count > len(v) {
+				dec.EndArray()
+				err = binary.ErrTypeMismatch
+				break
+			}
+			for j := 0; j < count && err == nil; j++ {
+				if n, err = v[j].DecodeBinaryFormat(dec, b[off+pos:]); err != nil {
+					err = dec.ElementError(err, b[off:], pos, j)
+				}
+				pos += n
+			}
+			dec.EndArray()
+			if err == nil {
+				x.Boxes, n = v, pos
+			}
+		case "Level":
+			v := int64(x.Level)
+			n, err = dec.DecodeInt(b[off:], &v)
+			if err == nil && int64(int8(v)) != v {
+				err = binary.ErrOverflow
+			}
+			x.Level = int8(v)
+		case "Note":
+			n, err = dec.DecodeString(b[off:], &x.Note)
+		default:
+			if n, err = dec.Skip(b[off:]); err == nil {
+				x.Unknown, err = dec.AppendUnknownField(x.Unknown, b[start:off+n])
+			}
+		}
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, name)
+		}
+		off += n
+	}
+	return off, nil
+}
+
+// MarshalBinaryFormat implements binary.Marshaler.
+func (x Item) MarshalBinaryFormat() ([]byte, error) {
+	return binary.AppendEncode(nil, &x)
+}
+
+// UnmarshalBinaryFormat implements binary.Unmarshaler.
+func (x *Item) UnmarshalBinaryFormat(b []byte) error {
+	_, err := binary.Decode(b, x)
+	return err
+}
+
+// AppendBinaryFormat implements binary.AppendMarshaler.
+func (x Item) AppendBinaryFormat(enc *binary.Encoder, b []byte) ([]byte, error) {
+	n := 4
+	if x.Qty != 0 {
+		n++
+	}
+	b, err := enc.AppendStructHeader(b, n)
+	if err != nil {
+		return nil, err
+	}
+	b = enc.AppendFieldName(b, "SKU")
+	b = enc.AppendString(b, x.SKU)
+	b = enc.AppendFieldName(b, "Price")
+	b = enc.AppendFixed(b, binary.Float64, math.Float64bits(x.Price))
+	if x.Qty != 0 {
+		b = enc.AppendFieldName(b, "Qty")
+		b = enc.AppendFixed(b, binary.Int32, uint64(x.Qty))
+	}
+	b = enc.AppendFieldName(b, "Count")
+	b = enc.AppendFixed(b, binary.Int, uint64(x.Count))
+	b = enc.AppendFieldName(b, "Seq")
+	b = enc.AppendFixed(b, binary.Uint16, uint64(x.Seq))
+	return b, nil
+}
+
+// DecodeBinaryFormat implements binary.DecodeUnmarshaler.
+func (x *Item) DecodeBinaryFormat(dec *binary.Decoder, b []byte) (int, error) {
+	if len(b) > 0 && b[0] == byte(binary.Nil) {
+		return 1, nil
+	}
+	l, off, err := dec.DecodeStructHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.EndStruct()
+	for i := 0; i < l; i++ {
+		name, n, err := dec.DecodeFieldName(b[off:])
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, nil)
+		}
+		off += n
+		switch string(name) {
+		case "SKU":
+			n, err = dec.DecodeString(b[off:], &x.SKU)
+		case "Price":
+			n, err = dec.DecodeFloat(b[off:], &x.Price)
+		case "Qty":
+			v := int64(x.Qty)
+			n, err = dec.DecodeInt(b[off:], &v)
+			if err == nil && int64(int32(v)) != v {
+				err = binary.ErrOverflow
+			}
+			x.Qty = int32(v)
+		case "Count":
+			v := int64(x.Count)
+			n, err = dec.DecodeInt(b[off:], &v)
+			if err == nil && int64(int(v)) != v {
+				err = binary.ErrOverflow
+			}
+			x.Count = int(v)
+		case "Seq":
+			v := uint64(x.Seq)
+			n, err = dec.DecodeUint(b[off:], &v)
+			if err == nil && uint64(uint16(v)) != v {
+				err = binary.ErrOverflow
+			}
+			x.Seq = uint16(v)
+		default:
+			n, err = dec.DecodeUnknownField(name, b[off:])
+		}
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, name)
+		}
+		off += n
+	}
+	return off, nil
+}
+
+// MarshalBinaryFormat implements binary.Marshaler.
+func (x Node) MarshalBinaryFormat() ([]byte, error) {
+	return binary.AppendEncode(nil, &x)
+}
+
+// UnmarshalBinaryFormat implements binary.Unmarshaler.
+func (x *Node) UnmarshalBinaryFormat(b []byte) error {
+	_, err := binary.Decode(b, x)
+	return err
+}
+
+// AppendBinaryFormat implements binary.AppendMarshaler.
+func (x Node) AppendBinaryFormat(enc *binary.Encoder, b []byte) ([]byte, error) {
+	n := 3
+	b, err := enc.AppendStructHeader(b, n)
+	if err != nil {
+		return nil, err
+	}
+	b = enc.AppendFieldName(b, "Name")
+	b = enc.AppendString(b, x.Name)
+	b = enc.AppendFieldName(b, "Next")
+	if x.Next == nil {
+		b = append(b, byte(binary.Nil))
+	} else {
+		if err = enc.EnterPointer(x.Next); err != nil {
+			return nil, enc.FieldError(err, "Next")
+		}
+		if b, err = x.Next.AppendBinaryFormat(enc, b); err != nil {
+			return nil, enc.FieldError(err, "Next")
+		}
+		enc.LeavePointer(x.Next)
+	}
+	b = enc.AppendFieldName(b, "Children")
+	if x.Children == nil {
+		b = append(b, byte(binary.Nil))
+	} else {
+		if err = enc.EnterPointer(x.Children); err != nil {
+			return nil, enc.FieldError(err, "Children")
+		}
+		if b, err = enc.AppendArrayHeader(b, len(x.Children)); err != nil {
+			return nil, enc.FieldError(err, "Children")
+		}
+		for i := range x.Children {
+			if b, err = x.Children[i].AppendBinaryFormat(enc, b); err != nil {
+				return nil, enc.FieldError(enc.ElementError(err, i), "Children")
+			}
+		}
+		enc.LeavePointer(x.Children)
+	}
+	return b, nil
+}
+
+// DecodeBinaryFormat implements binary.DecodeUnmarshaler.
+func (x *Node) DecodeBinaryFormat(dec *binary.Decoder, b []byte) (int, error) {
+	if len(b) > 0 && b[0] == byte(binary.Nil) {
+		return 1, nil
+	}
+	l, off, err := dec.DecodeStructHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.EndStruct()
+	for i := 0; i < l; i++ {
+		name, n, err := dec.DecodeFieldName(b[off:])
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, nil)
+		}
+		off += n
+		switch string(name) {
+		case "Name":
+			n, err = dec.DecodeString(b[off:], &x.Name)
+		case "Next":
+			if off < len(b) && b[off] == byte(binary.Nil) {
+				x.Next, n = nil, 1
+				break
+			}
+			if x.Next == nil {
+				if err = dec.Allocate(1, unsafe.Sizeof(Node{})); err != nil {
+					break
+				}
+				x.Next = new(Node)
+			}
+			n, err = x.Next.DecodeBinaryFormat(dec, b[off:])
+		case "Children":
+			if off < len(b) && b[off] == byte(binary.Nil) {
+				x.Children, n = nil, 1
+				break
+			}
+			var count, pos int
+			if count, pos, err = dec.DecodeArrayHeader(b[off:], unsafe.Sizeof(Node{})); err != nil {
+				break
+			}
+			v := make([]Node, 0, dec.SliceCap(b[off+pos:], count, unsafe.Sizeof(Node{})))
+			for j := 0; j < count && err == nil; j++ {
+				v = append(v, Node{})
+				if n, err = v[j].DecodeBinaryFormat(dec, b[off+pos:]); err != nil {
+					err = dec.ElementError(err, b[off:], pos, j)
+				}
+				pos += n
+			}
+			dec.EndArray()
+			if err == nil {
+				x.Children, n = v, pos
+			}
+		default:
+			n, err = dec.DecodeUnknownField(name, b[off:])
+		}
+		if err != nil {
+			return 0, dec.FieldError(err, b, off, name)
+		}
+		off += n
+	}
+	return off, nil
+}
@@ -0,0 +1,587 @@
+package gentest
+
+import (
+	"bytes"
+	stdbinary "encoding/binary"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hysios/binary"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainOrder and plainItem mirror Order and Item without the generated
+// methods, so they go through reflection.
+type plainOrder struct {
+	ID       uint64
+	Customer string `binary:"customer"`
+	Items    []plainItem
+	Total    float64
+	Discount float32 `binary:",omitempty"`
+	Paid     bool    `binary:",omitempty"`
+	Status   uint8   `binary:",omitempty"`
+	Created  time.Time
+	Timeout  time.Duration `binary:",omitempty"`
+	Labels   map[string]string
+	Raw      []byte
+	Ship     *plainItem `binary:",omitempty"`
+	Boxes    [2]plainItem
+	Level    int8
+	Note     string `binary:",omitempty"`
+	Internal string `binary:"-"`
+	secret   int
+	Unknown  binary.UnknownFields
+}
+
+type plainItem struct {
+	SKU   string
+	Price float64
+	Qty   int32 `binary:",omitempty"`
+	Count int
+	Seq   uint16
+}
+
+func plain(o Order) plainOrder {
+	p := plainOrder{
+		ID:       o.ID,
+		Customer: o.Customer,
+		Total:    o.Total,
+		Discount: o.Discount,
+		Paid:     o.Paid,
+		Status:   o.Status,
+		Created:  o.Created,
+		Timeout:  o.Timeout,
+		Labels:   o.Labels,
+		Raw:      o.Raw,
+		Level:    o.Level,
+		Note:     o.Note,
+		Unknown:  o.Unknown,
+		Boxes:    [2]plainItem{plainItem(o.Boxes[0]), plainItem(o.Boxes[1])},
+	}
+	if o.Items != nil {
+		p.Items = make([]plainItem, len(o.Items))
+		for i, it := range o.Items {
+			p.Items[i] = plainItem(it)
+		}
+	}
+	if o.Ship != nil {
+		ship := plainItem(*o.Ship)
+		p.Ship = &ship
+	}
+	return p
+}
+
+var testOrders = []Order{
+	{},
+	{
+		ID:       42,
+		Customer: "alice",
+		Items:    []Item{{"a", 1.5, 2, -1, 7}, {"b", 2.5, 0, 1 << 30, 0}},
+		Total:    4,
+		Discount: 0.5,
+		Paid:     true,
+		Status:   3,
+		Created:  time.Unix(1600000000, 42),
+		Timeout:  time.Minute,
+		Labels:   map[string]string{"x": "1", "y": "2"},
+		Raw:      []byte{1, 2, 3},
+		Ship:     &Item{SKU: "s", Qty: -3},
+		Boxes:    [2]Item{{SKU: "box"}},
+		Level:    -8,
+		Note:     "leave at door",
+	},
+	{ID: 1, Items: []Item{}, Raw: []byte{}, Labels: map[string]string{}},
+}
+
+var testOptions = map[string][]binary.Option{
+	"default":   nil,
+	"compact":   {binary.WithCompactInts()},
+	"varstring": {binary.WithVarStrings()},
+	"bigendian": {binary.WithByteOrder(stdbinary.BigEndian)},
+	"all": {
+		binary.WithCompactInts(),
+		binary.WithVarStrings(),
+		binary.WithPackedArrays(),
+		binary.WithByteOrder(stdbinary.BigEndian),
+	},
+}
+
+func TestGenerated_Encode(t *testing.T) {
+	for name, opts := range testOptions {
+		t.Run(name, func(t *testing.T) {
+			for _, o := range testOrders {
+				enc := binary.NewEncoderWithOptions(nil, opts...)
+				want, err := enc.Encode(plain(o))
+				assert.NoError(t, err)
+
+				got, err := o.AppendBinaryFormat(enc, nil)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got)
+
+				got, err = enc.Encode(o)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got)
+
+				got, err = enc.Encode(&o)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+func TestGenerated_Decode(t *testing.T) {
+	for name, opts := range testOptions {
+		t.Run(name, func(t *testing.T) {
+			for _, o := range testOrders {
+				b, err := binary.NewEncoderWithOptions(nil, opts...).Encode(plain(o))
+				assert.NoError(t, err)
+				dec := binary.NewDecoderWithOptions(nil, opts...)
+
+				var want plainOrder
+				_, err = dec.Decode(b, &want)
+				assert.NoError(t, err)
+
+				var got Order
+				n, err := got.DecodeBinaryFormat(dec, b)
+				assert.NoError(t, err)
+				assert.Equal(t, len(b), n)
+				assert.Equal(t, want, plain(got))
+
+				got = Order{}
+				n, err = dec.Decode(b, &got)
+				assert.NoError(t, err)
+				assert.Equal(t, len(b), n)
+				assert.Equal(t, want, plain(got))
+			}
+		})
+	}
+}
+
+func TestGenerated_Marshal(t *testing.T) {
+	o := testOrders[1]
+	b, err := o.MarshalBinaryFormat()
+	assert.NoError(t, err)
+
+	want, err := binary.Encode(plain(o))
+	assert.NoError(t, err)
+	assert.Equal(t, want, b)
+
+	var out Order
+	assert.NoError(t, out.UnmarshalBinaryFormat(b))
+	assert.True(t, out.Created.Equal(o.Created))
+	out.Created = o.Created
+	assert.Equal(t, o, out)
+}
+
+func TestGenerated_DecodeErrors(t *testing.T) {
+	b, err := binary.Encode(plain(testOrders[1]))
+	assert.NoError(t, err)
+
+	var out Order
+	for i := 0; i < len(b); i++ {
+		_, err := out.DecodeBinaryFormat(binary.NewDecoder(nil), b[:i])
+		assert.Error(t, err, "truncated to %d bytes", i)
+	}
+
+	_, err = out.DecodeBinaryFormat(binary.NewDecoder(nil), []byte{byte(binary.Int8), 1})
+	assert.Equal(t, binary.ErrTypeMismatch, err)
+
+	type extra struct {
+		ID    uint64
+		Extra string
+	}
+	b, err = binary.Encode(extra{1, "x"})
+	assert.NoError(t, err)
+	out = Order{}
+	n, err := binary.Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, uint64(1), out.ID)
+
+	// fields collected into UnknownFields are not reported
+	strict := binary.NewDecoderWithOptions(nil, binary.WithDisallowUnknownFields())
+	var want plainOrder
+	_, err = strict.Decode(b, &want)
+	assert.NoError(t, err)
+	_, err = strict.Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, want.Unknown, out.Unknown)
+
+	_, err = strict.Decode(b, &struct{ ID uint64 }{})
+	assert.True(t, errors.Is(err, binary.ErrUnknownField))
+}
+
+func TestGenerated_UnknownFields(t *testing.T) {
+	type orderV2 struct {
+		ID       uint64
+		Gift     bool
+		Customer string `binary:"customer"`
+		Tracking []string
+		Total    float64
+	}
+
+	in := orderV2{ID: 1, Gift: true, Customer: "bob", Tracking: []string{"a", "b"}, Total: 2}
+	for name, opts := range testOptions {
+		t.Run(name, func(t *testing.T) {
+			enc := binary.NewEncoderWithOptions(nil, opts...)
+			dec := binary.NewDecoderWithOptions(nil, opts...)
+			b, err := enc.Encode(in)
+			assert.NoError(t, err)
+
+			var want plainOrder
+			_, err = dec.Decode(b, &want)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, want.Unknown)
+
+			var got Order
+			n, err := got.DecodeBinaryFormat(dec, b)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, want, plain(got))
+
+			wantOut, err := enc.Encode(want)
+			assert.NoError(t, err)
+			gotOut, err := enc.Encode(got)
+			assert.NoError(t, err)
+			assert.Equal(t, wantOut, gotOut)
+
+			var out orderV2
+			_, err = dec.Decode(gotOut, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, in, out)
+
+			// decoding again replaces the unknown fields
+			_, err = got.DecodeBinaryFormat(dec, gotOut)
+			assert.NoError(t, err)
+			assert.Equal(t, want.Unknown, got.Unknown)
+		})
+	}
+}
+
+func TestGenerated_UnknownFieldsByteOrder(t *testing.T) {
+	type orderV2 struct {
+		ID    uint64
+		Total float64
+		Codes []uint32
+	}
+
+	in := orderV2{ID: 1, Total: 2.5, Codes: []uint32{1, 1 << 20}}
+	for from, fromOpts := range testOptions {
+		for to, toOpts := range testOptions {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				b, err := binary.NewEncoderWithOptions(nil, fromOpts...).Encode(in)
+				assert.NoError(t, err)
+
+				var relay Order
+				_, err = relay.DecodeBinaryFormat(binary.NewDecoderWithOptions(nil, fromOpts...), b)
+				assert.NoError(t, err)
+
+				out, err := relay.AppendBinaryFormat(binary.NewEncoderWithOptions(nil, toOpts...), nil)
+				assert.NoError(t, err)
+
+				var got orderV2
+				_, err = binary.NewDecoderWithOptions(nil, toOpts...).Decode(out, &got)
+				assert.NoError(t, err)
+				assert.Equal(t, in, got)
+			})
+		}
+	}
+}
+
+func TestGenerated_DecodeErrorPath(t *testing.T) {
+	type wireItem struct {
+		SKU   string
+		Price interface{}
+	}
+	type wireOrder struct {
+		ID    uint64
+		Items []wireItem
+	}
+
+	b, err := binary.Encode(wireOrder{ID: 1, Items: []wireItem{{"a", 1.0}, {"b", "bad"}}})
+	assert.NoError(t, err)
+
+	var want, got *binary.DecodeError
+	_, err = binary.Decode(b, &plainOrder{})
+	assert.True(t, errors.As(err, &want))
+	_, err = binary.Decode(b, &Order{})
+	assert.True(t, errors.As(err, &got))
+
+	assert.Equal(t, "plainOrder.Items[1].Price", want.Path)
+	assert.Equal(t, "Order.Items[1].Price", got.Path)
+	assert.Equal(t, want.Offset, got.Offset)
+	assert.Equal(t, want.Type, got.Type)
+	assert.True(t, errors.Is(got, binary.ErrTypeMismatch))
+}
+
+func TestGenerated_DecodeOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		path string
+	}{
+		{"narrower", struct{ Level int64 }{300}, "Level"},
+		{"negative", struct{ Status int8 }{-1}, "Status"},
+		{"negative uint64", struct{ ID int64 }{-1}, "ID"},
+		{"element", struct{ Items []struct{ Qty int64 } }{[]struct{ Qty int64 }{{1 << 40}}}, "Items[0].Qty"},
+		{"above int64", struct{ Items []struct{ Count uint64 } }{[]struct{ Count uint64 }{{1 << 63}}}, "Items[0].Count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, opts := range testOptions {
+				b, err := binary.NewEncoderWithOptions(nil, opts...).Encode(tt.val)
+				assert.NoError(t, err)
+
+				dec := binary.NewDecoderWithOptions(nil, opts...)
+				var want, got *binary.DecodeError
+				_, err = dec.Decode(b, &plainOrder{})
+				if assert.True(t, errors.As(err, &want), "got %v", err) {
+					assert.Equal(t, "plainOrder."+tt.path, want.Path)
+				}
+				_, err = dec.Decode(b, &Order{})
+				if assert.True(t, errors.As(err, &got), "got %v", err) {
+					assert.Equal(t, "Order."+tt.path, got.Path)
+				}
+				assert.True(t, errors.Is(want, binary.ErrOverflow), "got %v", want)
+				assert.True(t, errors.Is(got, binary.ErrOverflow), "got %v", got)
+			}
+		})
+	}
+}
+
+func TestGenerated_DecodeLimits(t *testing.T) {
+	b, err := binary.Encode(plain(testOrders[1]))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opt  binary.Option
+		err  error
+	}{
+		{"depth", binary.WithMaxDepth(1), binary.ErrMaxDepth},
+		{"elements", binary.WithMaxElements(2), binary.ErrMaxElements},
+		{"length", binary.WithMaxLength(4), binary.ErrMaxLength},
+		{"allocation", binary.WithMaxAllocation(4), binary.ErrMaxAllocation},
+		{"within limits", binary.WithMaxAllocation(1 << 20), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := binary.NewDecoderWithOptions(nil, tt.opt)
+
+			var out Order
+			_, err := dec.Decode(b, &out)
+			var want plainOrder
+			_, plainErr := dec.Decode(b, &want)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				assert.NoError(t, plainErr)
+				assert.Equal(t, want, plain(out))
+				return
+			}
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			assert.True(t, errors.Is(plainErr, tt.err), "got %v", plainErr)
+		})
+	}
+}
+
+// plainNode mirrors Node without the generated methods.
+type plainNode struct {
+	Name     string
+	Next     *plainNode
+	Children []plainNode
+}
+
+// chain returns n nodes linked through Next, or through their first child
+// when children is set.
+func chain(n int, children bool) *Node {
+	var head *Node
+	for i := 0; i < n; i++ {
+		node := &Node{Name: "n", Next: head}
+		if children && head != nil {
+			node.Next, node.Children = nil, []Node{*head}
+		}
+		head = node
+	}
+	return head
+}
+
+func TestGenerated_Nesting(t *testing.T) {
+	wide := &Node{Name: "root", Children: make([]Node, 50)}
+
+	tests := []struct {
+		name  string
+		node  *Node
+		depth int // depth the Decoder needs
+	}{
+		{"wide", wide, 3},
+		{"pointers", chain(20, false), 20},
+		{"children", chain(20, true), 39},
+		{"long", chain(3000, false), 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := binary.Encode(tt.node)
+			assert.NoError(t, err)
+
+			dec := binary.NewDecoderWithOptions(nil, binary.WithMaxDepth(tt.depth))
+			var got Node
+			_, err = dec.Decode(b, &got)
+			assert.NoError(t, err)
+			assert.Equal(t, *tt.node, got)
+
+			var want plainNode
+			_, err = dec.Decode(b, &want)
+			assert.NoError(t, err)
+			again, err := binary.Encode(want)
+			assert.NoError(t, err)
+			assert.Equal(t, b, again)
+
+			// generated and reflection decoding count depth alike
+			dec = binary.NewDecoderWithOptions(nil, binary.WithMaxDepth(tt.depth-1))
+			_, err = dec.Decode(b, &Node{})
+			assert.True(t, errors.Is(err, binary.ErrMaxDepth), "got %v", err)
+			_, err = dec.Decode(b, &plainNode{})
+			assert.True(t, errors.Is(err, binary.ErrMaxDepth), "got %v", err)
+		})
+	}
+}
+
+func TestGenerated_Cycle(t *testing.T) {
+	ptr := &Node{Name: "ptr"}
+	ptr.Next = ptr
+
+	slice := &Node{Name: "slice", Children: make([]Node, 1)}
+	slice.Children[0].Children = slice.Children
+
+	for _, node := range []*Node{ptr, slice} {
+		t.Run(node.Name, func(t *testing.T) {
+			_, err := binary.Encode(node)
+			var uve *binary.UnsupportedValueError
+			if assert.True(t, errors.As(err, &uve), "got %v", err) {
+				assert.Equal(t, "encountered a cycle", uve.Str)
+			}
+
+			_, err = node.MarshalBinaryFormat()
+			assert.True(t, errors.As(err, &uve), "got %v", err)
+		})
+	}
+}
+
+func TestGenerated_Time(t *testing.T) {
+	data, err := binary.Encode(Order{ID: 1})
+	assert.NoError(t, err)
+
+	var out Order
+	_, err = binary.Decode(data, &out)
+	assert.NoError(t, err)
+	assert.True(t, out.Created.IsZero(), "got %v", out.Created)
+
+	far := Order{Created: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, val := range []interface{}{far, plain(far)} {
+		_, err := binary.Encode(val)
+		var uve *binary.UnsupportedValueError
+		if assert.True(t, errors.As(err, &uve), "got %v", err) {
+			assert.True(t, strings.HasSuffix(uve.Path, "Order.Created"), "got %q", uve.Path)
+			assert.True(t, errors.Is(err, binary.ErrTimeRange), "got %v", err)
+		}
+	}
+}
+
+// benchOrder returns an order with enough items for their cost to show.
+func benchOrder() Order {
+	o := testOrders[1]
+	o.Items = make([]Item, 50)
+	for i := range o.Items {
+		o.Items[i] = Item{SKU: "sku", Price: float64(i), Qty: int32(i), Count: i, Seq: uint16(i)}
+	}
+	return o
+}
+
+func BenchmarkEncode(b *testing.B) {
+	o := benchOrder()
+	values := []struct {
+		name string
+		val  interface{}
+	}{
+		{"generated", o},
+		{"reflection", plain(o)},
+		{"generated item", o.Items[1]},
+		{"reflection item", plainItem(o.Items[1])},
+	}
+
+	// the buffer is reused, so that growing it does not hide the cost of
+	// encoding
+	var buf []byte
+	for _, v := range values {
+		b.Run(v.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if buf, err = binary.AppendEncode(buf[:0], v.val); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	o := benchOrder()
+	data, err := binary.Encode(o)
+	if err != nil {
+		b.Fatal(err)
+	}
+	item, err := binary.Encode(o.Items[1])
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	targets := []struct {
+		name string
+		data []byte
+		out  func() interface{}
+	}{
+		{"generated", data, func() interface{} { return new(Order) }},
+		{"reflection", data, func() interface{} { return new(plainOrder) }},
+		{"generated item", item, func() interface{} { return new(Item) }},
+		{"reflection item", item, func() interface{} { return new(plainItem) }},
+	}
+
+	for _, tt := range targets {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := binary.Decode(tt.data, tt.out()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerated_DecodeAllocationBound(t *testing.T) {
+	const count = 1 << 12
+	enc := binary.NewEncoder(nil)
+	b, err := enc.AppendStructHeader(nil, 1)
+	assert.NoError(t, err)
+	b = append(enc.AppendFieldName(b, "Items"), byte(binary.Array), 0, 0)
+	stdbinary.LittleEndian.PutUint16(b[len(b)-2:], count)
+	b = append(b, bytes.Repeat([]byte{byte(binary.MapKey)}, count)...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var got Order
+	_, err = got.DecodeBinaryFormat(binary.NewDecoder(nil), b)
+	runtime.ReadMemStats(&after)
+
+	assert.Error(t, err)
+	// without a bound the slice alone takes count items
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(16*len(b)))
+}
@@ -0,0 +1,82 @@
+package binary
+
+// decodeState is the state of a single call to Decode, shared by the nested
+// values it decodes.
+type decodeState struct {
+	Decoder
+	depth int // arrays, maps and structs being decoded
+	alloc int // bytes allocated for decoded values
+}
+
+// begin returns the Decoder that decodes a single value for dec, tracking
+// the depth and allocations of the values it decodes. Decoders, such as the
+// package level one, may be used by several goroutines, so the state lives
+// in a copy of dec. Calls made while decoding, such as those of the methods
+// generated by binarygen, continue with the state of the Decoder they get.
+func (dec *Decoder) begin() *Decoder {
+	if dec.st != nil {
+		return dec
+	}
+	st := &decodeState{Decoder: *dec}
+	st.Decoder.st = st
+	return &st.Decoder
+}
+
+// enter records that an array, map or struct is being decoded and fails
+// when that nests deeper than the maximum depth. Every successful enter
+// must be followed by a leave.
+func (dec *Decoder) enter() error {
+	if dec.st == nil {
+		return nil
+	}
+	if dec.st.depth >= dec.opts.depth() {
+		return ErrMaxDepth
+	}
+	dec.st.depth++
+	return nil
+}
+
+func (dec *Decoder) leave() {
+	if dec.st != nil {
+		dec.st.depth--
+	}
+}
+
+// allocate charges n values of size bytes about to be allocated to the
+// budget of the Decode in progress, failing when they exceed it.
+func (dec *Decoder) allocate(n int, size uintptr) error {
+	max := dec.opts.maxAlloc
+	if max <= 0 || size == 0 {
+		return nil
+	}
+
+	var used int
+	if dec.st != nil {
+		used = dec.st.alloc
+	}
+	if n > (max-used)/int(size) {
+		return ErrMaxAllocation
+	}
+	if dec.st != nil {
+		dec.st.alloc += n * int(size)
+	}
+	return nil
+}
+
+// checkElements fails when an array, map or struct of l elements exceeds
+// the maximum element count.
+func (dec *Decoder) checkElements(l uint64) error {
+	if max := dec.opts.maxElements; max > 0 && l > uint64(max) {
+		return ErrMaxElements
+	}
+	return nil
+}
+
+// checkLength fails when a string or bytes of l bytes exceeds the maximum
+// length.
+func (dec *Decoder) checkLength(l uint64) error {
+	if max := dec.opts.maxLength; max > 0 && l > uint64(max) {
+		return ErrMaxLength
+	}
+	return nil
+}
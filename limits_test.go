@@ -0,0 +1,221 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nested returns a value nested in depth arrays.
+func nested(depth int) interface{} {
+	var v interface{} = "leaf"
+	for i := 0; i < depth; i++ {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+// hostile returns a ten byte message whose header claims l elements or
+// bytes of typ.
+func hostile(typ Type, l uint32) []byte {
+	b := make([]byte, 10)
+	b[0] = byte(typ)
+	ByteOrder.PutUint32(b[1:], l)
+	return b
+}
+
+func TestDecoder_DecodeLimits(t *testing.T) {
+	type pair struct {
+		A, B string
+	}
+
+	tests := []struct {
+		name string
+		in   interface{}
+		out  interface{}
+		opts []Option
+		err  error
+	}{
+		{"depth", nested(5), new(interface{}), []Option{WithMaxDepth(5)}, nil},
+		{"depth exceeded", nested(6), new(interface{}), []Option{WithMaxDepth(5)}, ErrMaxDepth},
+		{"default depth", nested(DefaultMaxDepth), new(interface{}), nil, nil},
+		{"default depth exceeded", nested(DefaultMaxDepth + 1), new(interface{}), nil, ErrMaxDepth},
+		{"map depth", map[string]interface{}{"a": nested(1)}, new(map[string]interface{}), []Option{WithMaxDepth(1)}, ErrMaxDepth},
+		{"struct depth", []pair{{}}, new([]pair), []Option{WithMaxDepth(1)}, ErrMaxDepth},
+		{"elements", make([]int, 100), new([]int), []Option{WithMaxElements(100)}, nil},
+		{"elements exceeded", make([]int, 101), new([]int), []Option{WithMaxElements(100)}, ErrMaxElements},
+		{"packed elements", make([]int, 101), new([]int), []Option{WithPackedArrays(), WithMaxElements(100)}, ErrMaxElements},
+		{"bits elements", make([]bool, 101), new([]bool), []Option{WithBitPackedBools(), WithMaxElements(100)}, ErrMaxElements},
+		{"map elements", map[int]int{1: 1, 2: 2}, new(map[int]int), []Option{WithMaxElements(1)}, ErrMaxElements},
+		{"struct elements", pair{}, new(pair), []Option{WithMaxElements(1)}, ErrMaxElements},
+		{"length", "hello", new(string), []Option{WithMaxLength(5)}, nil},
+		{"length exceeded", "hello", new(string), []Option{WithMaxLength(4)}, ErrMaxLength},
+		{"varstring length", "hello", new(string), []Option{WithVarStrings(), WithMaxLength(4)}, ErrMaxLength},
+		{"bytes length", []byte("hello"), new([]byte), []Option{WithMaxLength(4)}, ErrMaxLength},
+		{"allocation", make([]int64, 100), new([]int64), []Option{WithMaxAllocation(800)}, nil},
+		{"allocation exceeded", make([]int64, 100), new([]int64), []Option{WithMaxAllocation(799)}, ErrMaxAllocation},
+		{"packed allocation", make([]int64, 100), new([]int64), []Option{WithPackedArrays(), WithMaxAllocation(799)}, ErrMaxAllocation},
+		{"string allocation", pair{"hello", "world"}, new(pair), []Option{WithMaxAllocation(10)}, nil},
+		{"allocation summed", pair{"hello", "world"}, new(pair), []Option{WithMaxAllocation(9)}, ErrMaxAllocation},
+		{"pointer allocation", int64(1), new(*int64), []Option{WithMaxAllocation(7)}, ErrMaxAllocation},
+		{"map allocation", map[int64]int64{1: 1}, new(map[int64]int64), []Option{WithMaxAllocation(15)}, ErrMaxAllocation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(tt.in)
+			if len(tt.opts) > 0 {
+				b, err = NewEncoderWithOptions(nil, tt.opts...).Encode(tt.in)
+			}
+			assert.NoError(t, err)
+
+			_, err = NewDecoderWithOptions(nil, tt.opts...).Decode(b, tt.out)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			var de *DecodeError
+			assert.True(t, errors.As(err, &de), "got %T", err)
+		})
+	}
+}
+
+func TestDecoder_DecodeLimitsHostile(t *testing.T) {
+	var huge [binary.MaxVarintLen64]byte
+	varString := append([]byte{byte(VarString)}, huge[:binary.PutUvarint(huge[:], 1<<40)]...)
+
+	tests := []struct {
+		name string
+		in   []byte
+		opts []Option
+		err  error
+	}{
+		{"array", hostile(Array, 0xFFFF), []Option{WithMaxElements(1000)}, ErrMaxElements},
+		{"map", hostile(Map, 0xFFFFFFFF), []Option{WithMaxElements(1000)}, ErrMaxElements},
+		{"struct", hostile(Struct, 0xFFFFFFFF), []Option{WithMaxElements(1000)}, ErrMaxElements},
+		{"bytes", hostile(Bytes, 0xFFFFFFFF), []Option{WithMaxLength(1 << 20)}, ErrMaxLength},
+		{"varstring", varString, []Option{WithMaxLength(1 << 20)}, ErrMaxLength},
+		{"map unlimited", hostile(Map, 0xFFFFFFFF), nil, ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoderWithOptions(nil, tt.opts...)
+			_, err := dec.Decode(tt.in, new(interface{}))
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+
+			// a stream fails on the header, without waiting for the rest
+			dec = NewDecoderWithOptions(bytes.NewReader(tt.in), tt.opts...)
+			err = dec.DecodeNext(new(interface{}))
+			if tt.opts != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeLimitsPerCall(t *testing.T) {
+	b, err := Encode([]string{"hello", "world"})
+	assert.NoError(t, err)
+
+	dec := NewDecoderWithOptions(nil, WithMaxAllocation(64), WithMaxDepth(1))
+	for i := 0; i < 3; i++ {
+		var out []string
+		_, err := dec.Decode(b, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"hello", "world"}, out)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		buf.Write(b)
+	}
+	dec = NewDecoderWithOptions(&buf, WithMaxAllocation(64), WithMaxDepth(1))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, dec.DecodeNext(new([]string)))
+	}
+}
+
+// allocated returns the bytes allocated by f.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestDecoder_DecodeAllocationBound(t *testing.T) {
+	type large struct{ A [256]int64 }
+
+	const count = 1 << 12
+	array := append([]byte{byte(Array), 0, 0}, bytes.Repeat([]byte{byte(MapKey)}, count)...)
+	binary.LittleEndian.PutUint16(array[1:], count)
+	packed := append([]byte{byte(Packed), byte(ArrayInt8), 0x80, 0x20}, make([]byte, count)...)
+	bits := append([]byte{byte(BitArray), 0x80, 0x80, 0x02}, make([]byte, count)...)
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"array", array},
+		{"packed", packed},
+		{"bits", bits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			n := allocated(func() {
+				_, err = Decode(tt.b, new([]large))
+			})
+			assert.Error(t, err)
+			// without a bound the slice alone takes count*2KiB
+			assert.Less(t, n, uint64(16*len(tt.b)))
+		})
+	}
+}
+
+func TestStream_Limits(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		opts []Option
+		err  error
+	}{
+		{"depth", nested(3), []Option{WithMaxDepth(2)}, ErrMaxDepth},
+		{"elements", []int{1, 2, 3}, []Option{WithMaxElements(2)}, ErrMaxElements},
+		{"string", "hello", []Option{WithMaxLength(4)}, ErrMaxLength},
+		{"long string", strings.Repeat("a", 1<<13), []Option{WithMaxLength(1 << 12)}, ErrMaxLength},
+		{"varstring", "hello", []Option{WithVarStrings(), WithMaxLength(4)}, ErrMaxLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewEncoderWithOptions(nil, tt.opts...).Encode(tt.in)
+			assert.NoError(t, err)
+
+			dec := NewDecoderWithOptions(bytes.NewReader(b), tt.opts...)
+			err = dec.DecodeNext(new(interface{}))
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestSkip_Depth(t *testing.T) {
+	b, err := Encode(nested(3))
+	assert.NoError(t, err)
+
+	n, err := NewDecoderWithOptions(nil, WithMaxDepth(3)).Skip(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+
+	_, err = NewDecoderWithOptions(nil, WithMaxDepth(2)).Skip(b)
+	assert.True(t, errors.Is(err, ErrMaxDepth), "got %v", err)
+}
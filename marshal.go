@@ -0,0 +1,186 @@
+package binary
+
+import (
+	"encoding"
+	"reflect"
+	"time"
+)
+
+// Marshaler is implemented by types that encode themselves. The returned
+// bytes must hold exactly one complete encoded value, tag included.
+type Marshaler interface {
+	MarshalBinaryFormat() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves. The input
+// holds exactly one complete encoded value, tag included.
+type Unmarshaler interface {
+	UnmarshalBinaryFormat([]byte) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	appendMarshalerType   = reflect.TypeOf((*AppendMarshaler)(nil)).Elem()
+	decodeUnmarshalerType = reflect.TypeOf((*DecodeUnmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType              = reflect.TypeOf(time.Time{})
+	durationType          = reflect.TypeOf(time.Duration(0))
+	bytesType             = reflect.TypeOf([]byte(nil))
+)
+
+// implements reports whether values of type t implement the interface typ,
+// and whether they only do so through a pointer.
+func implements(t, typ reflect.Type) (ok, ptr bool) {
+	if t.Implements(typ) {
+		return true, false
+	}
+	return reflect.PtrTo(t).Implements(typ), true
+}
+
+// receiver returns v as the receiver of its methods. When ptr is set, or v
+// is addressable anyway, that is the address of v, or of a copy of it.
+func receiver(v reflect.Value, ptr bool) interface{} {
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	if !ptr {
+		return v.Interface()
+	}
+
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.Interface()
+}
+
+// marshalerEncoder returns the encoder of a type implementing
+// AppendMarshaler or Marshaler, or nil when t implements neither.
+func marshalerEncoder(t reflect.Type) encoderFunc {
+	if ok, ptr := implements(t, appendMarshalerType); ok {
+		return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+			if v.Kind() == reflect.Ptr && v.IsNil() {
+				return append(b, byte(Nil)), nil
+			}
+
+			// unlike the bytes of a Marshaler the appended ones are
+			// trusted, which would otherwise be scanned again at every
+			// level of nested generated types
+			return receiver(v, ptr).(AppendMarshaler).AppendBinaryFormat(enc, b)
+		}
+	}
+
+	ok, ptr := implements(t, marshalerType)
+	if !ok {
+		return nil
+	}
+
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return append(b, byte(Nil)), nil
+		}
+
+		data, err := receiver(v, ptr).(Marshaler).MarshalBinaryFormat()
+		if err != nil {
+			return nil, err
+		}
+		if n, err := enc.decoder().skip(data); err != nil || n != len(data) {
+			return nil, ErrInvalidMarshal
+		}
+		return append(b, data...), nil
+	}
+}
+
+// decoder returns a Decoder sharing the options of enc.
+func (enc *Encoder) decoder() *Decoder {
+	return &Decoder{opts: enc.opts}
+}
+
+// stdMarshalerEncoder returns the encoder of a type implementing
+// encoding.BinaryMarshaler, which writes Bytes, or encoding.TextMarshaler,
+// which writes a String. It returns nil when t implements neither.
+func stdMarshalerEncoder(t reflect.Type) encoderFunc {
+	if ok, ptr := implements(t, binaryMarshalerType); ok {
+		return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+			data, err := receiver(v, ptr).(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			return enc.appendValue(b, data)
+		}
+	}
+
+	if ok, ptr := implements(t, textMarshalerType); ok {
+		return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+			text, err := receiver(v, ptr).(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			return enc.appendString(b, String, VarString, string(text)), nil
+		}
+	}
+	return nil
+}
+
+// unmarshalerDecoder wraps the decoder f of type t when t implements
+// DecodeUnmarshaler or Unmarshaler, or when it implements
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler, which decode Bytes
+// and Strings respectively.
+func unmarshalerDecoder(t reflect.Type, f decoderFunc) decoderFunc {
+	pt := reflect.PtrTo(t)
+	if pt.Implements(decodeUnmarshalerType) {
+		return func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+			if !v.CanAddr() {
+				return f(dec, b, v)
+			}
+			return v.Addr().Interface().(DecodeUnmarshaler).DecodeBinaryFormat(dec, b)
+		}
+	}
+	if pt.Implements(unmarshalerType) {
+		return func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+			if !v.CanAddr() {
+				return f(dec, b, v)
+			}
+
+			n, err := dec.skip(b)
+			if err != nil {
+				return 0, err
+			}
+			return n, v.Addr().Interface().(Unmarshaler).UnmarshalBinaryFormat(b[:n])
+		}
+	}
+
+	var (
+		bin  = pt.Implements(binaryUnmarshalerType)
+		text = pt.Implements(textUnmarshalerType)
+	)
+	if !bin && !text {
+		return f
+	}
+
+	return func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+		if !v.CanAddr() {
+			return f(dec, b, v)
+		}
+
+		switch {
+		case bin && hasTag(b, Bytes):
+			var data []byte
+			n, err := dec.decodeValue(b, reflect.ValueOf(&data).Elem())
+			if err != nil {
+				return 0, err
+			}
+			return n, v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+		case text && (hasTag(b, String) || hasTag(b, VarString)):
+			var s string
+			n, err := dec.decodeValue(b, reflect.ValueOf(&s).Elem())
+			if err != nil {
+				return 0, err
+			}
+			return n, v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+		return f(dec, b, v)
+	}
+}
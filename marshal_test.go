@@ -0,0 +1,108 @@
+package binary
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// money is encoded as its amount in cents.
+type money struct {
+	units, cents int
+}
+
+func (m money) MarshalBinaryFormat() ([]byte, error) {
+	return Encode(int64(m.units*100 + m.cents))
+}
+
+func (m *money) UnmarshalBinaryFormat(b []byte) error {
+	var c int64
+	if _, err := Decode(b, &c); err != nil {
+		return err
+	}
+	m.units, m.cents = int(c/100), int(c%100)
+	return nil
+}
+
+// level is encoded through encoding.TextMarshaler.
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("*", int(l))), nil
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	*l = level(len(text))
+	return nil
+}
+
+// id is encoded through encoding.BinaryMarshaler.
+type id struct {
+	hi, lo byte
+}
+
+func (i id) MarshalBinary() ([]byte, error) {
+	return []byte{i.hi, i.lo}, nil
+}
+
+func (i *id) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("bad id")
+	}
+	i.hi, i.lo = b[0], b[1]
+	return nil
+}
+
+type badMarshal struct{}
+
+func (badMarshal) MarshalBinaryFormat() ([]byte, error) {
+	return []byte{byte(Uint32), 1}, nil
+}
+
+func TestMarshaler(t *testing.T) {
+	b, err := Encode(money{units: 12, cents: 34})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(Int64), 0xD2, 0x04, 0, 0, 0, 0, 0, 0}, b)
+
+	var m money
+	_, err = Decode(b, &m)
+	assert.NoError(t, err)
+	assert.Equal(t, money{units: 12, cents: 34}, m)
+
+	_, err = Encode(badMarshal{})
+	assert.Equal(t, ErrInvalidMarshal, err)
+}
+
+func TestMarshaler_Nested(t *testing.T) {
+	type order struct {
+		Total  money
+		Level  level
+		ID     id
+		Prices map[string]money
+	}
+
+	in := order{
+		Total:  money{units: 5, cents: 1},
+		Level:  3,
+		ID:     id{hi: 1, lo: 2},
+		Prices: map[string]money{"a": {units: 1}, "b": {cents: 99}},
+	}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+
+	var out order
+	n, err := Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, in, out)
+
+	b, err = Encode([]interface{}{money{units: 1}, level(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(Array), 2, 0,
+		byte(Int64), 100, 0, 0, 0, 0, 0, 0, 0,
+		byte(String), '*', '*', 0,
+	}, b)
+}
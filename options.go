@@ -0,0 +1,141 @@
+package binary
+
+import (
+	"encoding/binary"
+)
+
+// Option configures an Encoder or a Decoder.
+type Option func(*options)
+
+type options struct {
+	byteOrder  binary.ByteOrder
+	varStrings bool
+	packed     bool
+	bits       bool
+	compact    bool
+	strict     bool
+
+	maxDepth    int
+	maxElements int
+	maxLength   int
+	maxAlloc    int
+}
+
+// DefaultMaxDepth is the nesting depth Decoders without WithMaxDepth allow.
+const DefaultMaxDepth = 10000
+
+// WithByteOrder sets the byte order of fixed width values and length
+// prefixes. Encoders and Decoders without it use the package ByteOrder.
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(o *options) {
+		o.byteOrder = order
+	}
+}
+
+// WithVarStrings makes an Encoder write strings and struct field names with
+// a uvarint length prefix instead of a NUL terminator, so they may contain
+// NUL bytes. Decoders read both forms regardless of this option.
+func WithVarStrings() Option {
+	return func(o *options) {
+		o.varStrings = true
+	}
+}
+
+// WithPackedArrays makes an Encoder write typed arrays of fixed width
+// elements as Packed arrays, storing the raw element bytes without a tag per
+// element. Decoders read both forms regardless of this option.
+func WithPackedArrays() Option {
+	return func(o *options) {
+		o.packed = true
+	}
+}
+
+// WithBitPackedBools makes an Encoder write bool slices as a BitArray,
+// storing eight values per byte. Decoders read both forms regardless of this
+// option.
+func WithBitPackedBools() Option {
+	return func(o *options) {
+		o.bits = true
+	}
+}
+
+// WithCompactInts makes an Encoder write int and int64 values as zigzag
+// Varints, uint and uint64 values as Uvarints, and the counts of arrays,
+// maps, structs and bytes as VarLen prefixed uvarints, so that small values
+// take a byte or two. Integers of 8 to 32 bits keep their fixed width, as do
+// the elements of packed arrays. Decoders read both forms regardless of this
+// option, but Varints and Uvarints do not record which type they were
+// written from: decoded into an interface they are int64 and uint64, also
+// where an int or uint was encoded.
+func WithCompactInts() Option {
+	return func(o *options) {
+		o.compact = true
+	}
+}
+
+// WithDisallowUnknownFields makes a Decoder fail with ErrUnknownField when a
+// Struct holds a field the target struct has no field for. Without it such
+// fields are skipped. Encoders ignore this option.
+func WithDisallowUnknownFields() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMaxDepth makes a Decoder fail with ErrMaxDepth on values nested in
+// more than n arrays, maps and structs, instead of DefaultMaxDepth. Encoders
+// ignore this option.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// WithMaxElements makes a Decoder fail with ErrMaxElements on arrays, maps
+// and structs of more than n elements, entries or fields. Encoders ignore
+// this option.
+func WithMaxElements(n int) Option {
+	return func(o *options) {
+		o.maxElements = n
+	}
+}
+
+// WithMaxLength makes a Decoder fail with ErrMaxLength on strings and bytes
+// longer than n bytes. Encoders ignore this option.
+func WithMaxLength(n int) Option {
+	return func(o *options) {
+		o.maxLength = n
+	}
+}
+
+// WithMaxAllocation makes a Decoder fail with ErrMaxAllocation when the
+// values a single Decode stores would take more than n bytes, counting the
+// slices, maps, strings and pointers it allocates by the size of their
+// elements. Encoders ignore this option.
+func WithMaxAllocation(n int) Option {
+	return func(o *options) {
+		o.maxAlloc = n
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o *options) order() binary.ByteOrder {
+	if o.byteOrder != nil {
+		return o.byteOrder
+	}
+	return ByteOrder
+}
+
+func (o *options) depth() int {
+	if o.maxDepth > 0 {
+		return o.maxDepth
+	}
+	return DefaultMaxDepth
+}
@@ -0,0 +1,568 @@
+package binary
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// encoderFunc appends the encoding of v to b.
+type encoderFunc func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error)
+
+// decoderFunc decodes the value at the start of b into v and returns the
+// number of bytes it took.
+type decoderFunc func(dec *Decoder, b []byte, v reflect.Value) (int, error)
+
+// The encoders and decoders of each type are compiled once and cached, so
+// type dispatch and struct field lookup do not happen again for every value.
+var (
+	encoders       sync.Map // map[reflect.Type]encoderFunc
+	scalarEncoders sync.Map // map[reflect.Type]encoderFunc
+	decoders       sync.Map // map[reflect.Type]decoderFunc
+)
+
+// typeEncoder returns the encoder of values of type t.
+func typeEncoder(t reflect.Type) encoderFunc {
+	if f, ok := encoders.Load(t); ok {
+		return f.(encoderFunc)
+	}
+
+	// a recursive type reaches itself while its encoder is compiled, so a
+	// placeholder that waits for the compiled encoder is stored first
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoders.LoadOrStore(t, encoderFunc(func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		wg.Wait()
+		return f(enc, b, v)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
+	}
+
+	f = newTypeEncoder(t)
+	wg.Done()
+	encoders.Store(t, f)
+	return f
+}
+
+// scalarEncoder returns the encoder of values of type t used as map keys and
+// elements, which only accepts scalars and types that marshal themselves.
+func scalarEncoder(t reflect.Type) encoderFunc {
+	if f, ok := scalarEncoders.Load(t); ok {
+		return f.(encoderFunc)
+	}
+
+	f, _ := scalarEncoders.LoadOrStore(t, newScalarEncoder(t))
+	return f.(encoderFunc)
+}
+
+// typeDecoder returns the decoder of values into type t.
+func typeDecoder(t reflect.Type) decoderFunc {
+	if f, ok := decoders.Load(t); ok {
+		return f.(decoderFunc)
+	}
+
+	var (
+		wg sync.WaitGroup
+		f  decoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := decoders.LoadOrStore(t, decoderFunc(func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+		wg.Wait()
+		return f(dec, b, v)
+	}))
+	if loaded {
+		return fi.(decoderFunc)
+	}
+
+	f = newTypeDecoder(t)
+	wg.Done()
+	decoders.Store(t, f)
+	return f
+}
+
+func newTypeEncoder(t reflect.Type) encoderFunc {
+	if t.Kind() == reflect.Interface {
+		return encodeInterface
+	}
+	if f := marshalerEncoder(t); f != nil {
+		return f
+	}
+
+	f := newValueEncoder(t)
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return nilEncoder(f)
+	}
+	return f
+}
+
+func newValueEncoder(t reflect.Type) encoderFunc {
+	if f := basicEncoder(t); f != nil {
+		return f
+	}
+	if t == bytesType {
+		return encodeBytes
+	}
+	if f := stdMarshalerEncoder(t); f != nil {
+		return f
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return ptrEncoder(t)
+	case reflect.Slice:
+		// named slices of a type with its own array tag use that tag
+		if st := reflect.SliceOf(t.Elem()); st != t {
+			return convertEncoder(st)
+		}
+		return arrayEncoder(t)
+	case reflect.Array:
+		return arrayEncoder(t)
+	case reflect.Struct:
+		return structEncoder(t)
+	case reflect.Map:
+		return mapEncoder(t)
+	}
+	return unsupportedEncoder
+}
+
+func newScalarEncoder(t reflect.Type) encoderFunc {
+	if t.Kind() == reflect.Interface {
+		return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+			if v.IsNil() {
+				return nil, &UnsupportedValueError{Value: v, Str: "nil"}
+			}
+			return scalarEncoder(v.Elem().Type())(enc, b, v.Elem())
+		}
+	}
+
+	f := marshalerEncoder(t)
+	if f == nil {
+		f = basicEncoder(t)
+	}
+	if f == nil {
+		f = stdMarshalerEncoder(t)
+	}
+	switch {
+	case f == nil:
+		return unsupportedEncoder
+	case t.Kind() == reflect.Ptr:
+		// nil pointers have no value to marshal
+		return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+			if v.IsNil() {
+				return nil, &UnsupportedValueError{Value: v, Str: "nil"}
+			}
+			return f(enc, b, v)
+		}
+	}
+	return f
+}
+
+// basicEncoder returns the encoder of the predeclared scalar types and of
+// time.Time and time.Duration, or nil for any other type.
+func basicEncoder(t reflect.Type) encoderFunc {
+	switch t {
+	case timeType:
+		return fixedEncoder(Timestamp)
+	case durationType:
+		return fixedEncoder(Duration)
+	}
+	if t.PkgPath() != "" {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Uint8:
+		return fixedEncoder(Uint8)
+	case reflect.Uint16:
+		return fixedEncoder(Uint16)
+	case reflect.Uint32:
+		return fixedEncoder(Uint32)
+	case reflect.Uint64:
+		return fixedEncoder(Uint64)
+	case reflect.Uint:
+		return fixedEncoder(Uint)
+	case reflect.Int8:
+		return fixedEncoder(Int8)
+	case reflect.Int16:
+		return fixedEncoder(Int16)
+	case reflect.Int32:
+		return fixedEncoder(Int32)
+	case reflect.Int64:
+		return fixedEncoder(Int64)
+	case reflect.Int:
+		return fixedEncoder(Int)
+	case reflect.Float32:
+		return fixedEncoder(Float32)
+	case reflect.Float64:
+		return fixedEncoder(Float64)
+	case reflect.Bool:
+		return fixedEncoder(Bool)
+	case reflect.String:
+		return encodeString
+	}
+	return nil
+}
+
+func fixedEncoder(typ Type) encoderFunc {
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		x, err := fixedBits(v)
+		if err != nil {
+			return nil, err
+		}
+		return enc.appendFixed(b, typ, x), nil
+	}
+}
+
+func encodeString(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+	return enc.appendString(b, String, VarString, v.String()), nil
+}
+
+func encodeBytes(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+	b, err := enc.appendBytes(b, v.Bytes())
+	if err != nil {
+		return nil, lengthError(v, err)
+	}
+	return b, nil
+}
+
+func encodeInterface(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+	if v.IsNil() {
+		return append(b, byte(Nil)), nil
+	}
+	return typeEncoder(v.Elem().Type())(enc, b, v.Elem())
+}
+
+func unsupportedEncoder(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+	return nil, &UnsupportedTypeError{Type: v.Type()}
+}
+
+// lengthError returns the error of appending the header of v, which fails
+// when the length of v overflows it.
+func lengthError(v reflect.Value, err error) error {
+	if err == ErrArrayTooLong {
+		return &UnsupportedValueError{Value: v, Str: "of length " + strconv.Itoa(v.Len()), Err: err}
+	}
+	return err
+}
+
+// nilEncoder wraps the encoder f of a pointer, map or slice type so that nil
+// values are written as Nil.
+func nilEncoder(f encoderFunc) encoderFunc {
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return append(b, byte(Nil)), nil
+		}
+		return f(enc, b, v)
+	}
+}
+
+func ptrEncoder(t reflect.Type) encoderFunc {
+	elem := typeEncoder(t.Elem())
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		if err := enc.enter(v); err != nil {
+			return nil, err
+		}
+		b, err := elem(enc, b, v.Elem())
+		enc.leave(v)
+		return b, err
+	}
+}
+
+// startDetectingCyclesAfter is the number of nested pointers, maps and
+// slices past which an Encoder checks for cycles, which would otherwise
+// recurse until the stack overflows. Like encoding/json, it does not check
+// shallower values to save the cost.
+const startDetectingCyclesAfter = 1000
+
+// enter records that the pointer, map or slice v is being encoded, failing
+// when v is already being encoded further up. Every successful enter must be
+// followed by a leave.
+func (enc *Encoder) enter(v reflect.Value) error {
+	st := enc.st
+	if st == nil {
+		return nil
+	}
+	if st.ptrLevel++; st.ptrLevel <= startDetectingCyclesAfter {
+		return nil
+	}
+
+	key := cycleKey(v)
+	if _, ok := st.ptrSeen[key]; ok {
+		st.ptrLevel--
+		return &UnsupportedValueError{Value: v, Str: "encountered a cycle"}
+	}
+	if st.ptrSeen == nil {
+		st.ptrSeen = make(map[interface{}]struct{})
+	}
+	st.ptrSeen[key] = struct{}{}
+	return nil
+}
+
+func (enc *Encoder) leave(v reflect.Value) {
+	st := enc.st
+	if st == nil {
+		return
+	}
+	if st.ptrLevel > startDetectingCyclesAfter {
+		delete(st.ptrSeen, cycleKey(v))
+	}
+	st.ptrLevel--
+}
+
+// cycleKey identifies the pointer, map or slice v. Slices are told apart by
+// their length too, since a slice and its subslices share a pointer.
+func cycleKey(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice {
+		return struct {
+			ptr uintptr
+			len int
+		}{v.Pointer(), v.Len()}
+	}
+	return v.Pointer()
+}
+
+func convertEncoder(t reflect.Type) encoderFunc {
+	f := typeEncoder(t)
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		return f(enc, b, v.Convert(t))
+	}
+}
+
+// arrayEncoder encodes slices and arrays of t under the array tag of t, or
+// as a heterogeneous Array when t has no tag of its own.
+func arrayEncoder(t reflect.Type) encoderFunc {
+	typ, ok := arrayTags[t]
+	if !ok {
+		typ = Array
+	}
+	_, packed := arrayElems[typ]
+	elem := typeEncoder(t.Elem())
+
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		switch {
+		case typ == ArrayBool && enc.opts.bits:
+			return enc.appendBits(b, v), nil
+		case packed && enc.opts.packed:
+			return enc.appendPacked(b, typ, v)
+		}
+
+		b, err := enc.appendHeader(b, typ, v.Len())
+		if err != nil {
+			return nil, lengthError(v, err)
+		}
+		if v.Kind() == reflect.Slice {
+			if err := enc.enter(v); err != nil {
+				return nil, err
+			}
+			defer enc.leave(v)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if b, err = elem(enc, b, v.Index(i)); err != nil {
+				return nil, encodeError(err, index(i))
+			}
+		}
+		return b, nil
+	}
+}
+
+func structEncoder(t reflect.Type) encoderFunc {
+	var (
+		fields  = cachedTypeFields(t)
+		encs    = make([]encoderFunc, len(fields))
+		unknown = unknownFieldsIndex(t)
+	)
+	for i, f := range fields {
+		encs[i] = typeEncoder(t.Field(f.index).Type)
+	}
+
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		count := 0
+		for _, f := range fields {
+			if !f.omitEmpty || !isEmptyValue(v.Field(f.index)) {
+				count++
+			}
+		}
+
+		var extra []byte
+		if unknown >= 0 {
+			extra = v.Field(unknown).Bytes()
+			n, err := unknownDecoder.countFields(extra)
+			if err != nil {
+				return nil, err
+			}
+			count += n
+		}
+
+		b, err := enc.appendHeader(b, Struct, count)
+		if err != nil {
+			return nil, err
+		}
+		for i, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			b = enc.appendString(b, StructField, VarStructField, f.name)
+			b = append(b, byte(StructValue))
+			if b, err = encs[i](enc, b, fv); err != nil {
+				return nil, encodeError(err, "."+f.name)
+			}
+		}
+		return enc.appendUnknownFields(b, extra)
+	}
+}
+
+// isNaN reports whether v, or the value in the interface v, is a NaN float.
+func isNaN(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.IsNaN(v.Float())
+	}
+	return false
+}
+
+// mapEntry locates an encoded key and value in a scratch buffer.
+type mapEntry struct {
+	key, val, end int
+}
+
+func mapEncoder(t reflect.Type) encoderFunc {
+	var (
+		keyEnc = scalarEncoder(t.Key())
+		valEnc = typeEncoder(t.Elem())
+	)
+
+	return func(enc *Encoder, b []byte, v reflect.Value) ([]byte, error) {
+		if err := enc.enter(v); err != nil {
+			return nil, err
+		}
+		defer enc.leave(v)
+
+		var (
+			scratch []byte
+			entries = make([]mapEntry, 0, v.Len())
+			iter    = v.MapRange()
+			err     error
+		)
+		for iter.Next() {
+			key := iter.Key()
+			if isNaN(key) {
+				// NaN keys are never equal, so they can not be decoded back
+				return nil, &UnsupportedValueError{Value: key, Str: "NaN map key"}
+			}
+
+			e := mapEntry{key: len(scratch)}
+			if scratch, err = keyEnc(enc, scratch, key); err != nil {
+				return nil, err
+			}
+			e.val = len(scratch)
+			if scratch, err = valEnc(enc, scratch, iter.Value()); err != nil {
+				return nil, encodeError(err, fmt.Sprintf("[%v]", key))
+			}
+			e.end = len(scratch)
+			entries = append(entries, e)
+		}
+		// entries are ordered by their encoded keys so that equal maps
+		// always produce the same bytes
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(scratch[entries[i].key:entries[i].val], scratch[entries[j].key:entries[j].val]) < 0
+		})
+
+		if b, err = enc.appendHeader(b, Map, len(entries)); err != nil {
+			return nil, lengthError(v, err)
+		}
+		for _, e := range entries {
+			b = append(b, byte(MapKey))
+			b = append(b, scratch[e.key:e.val]...)
+			b = append(b, byte(MapValue))
+			b = append(b, scratch[e.val:e.end]...)
+		}
+		return b, nil
+	}
+}
+
+func newTypeDecoder(t reflect.Type) decoderFunc {
+	var f decoderFunc
+	switch t.Kind() {
+	case reflect.Interface:
+		return (*Decoder).decodeValue
+	case reflect.Ptr:
+		f = ptrDecoder(t)
+	case reflect.Struct:
+		f = structDecoder(t)
+	default:
+		f = (*Decoder).decodeValue
+	}
+	return unmarshalerDecoder(t, f)
+}
+
+// ptrDecoder decodes into the value a pointer points to, allocating it when
+// the pointer is nil. A Nil value sets the pointer to nil instead.
+func ptrDecoder(t reflect.Type) decoderFunc {
+	elem := typeDecoder(t.Elem())
+	return func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+		if len(b) == 0 {
+			return 0, ErrBufTooSmall
+		}
+		if Type(b[0]) == Nil {
+			v.Set(reflect.Zero(v.Type()))
+			return 1, nil
+		}
+
+		if v.IsNil() {
+			if err := dec.allocate(1, t.Elem().Size()); err != nil {
+				return 0, err
+			}
+			v.Set(reflect.New(t.Elem()))
+		}
+		return elem(dec, b, v.Elem())
+	}
+}
+
+// fieldDecoder decodes the value of a struct field.
+type fieldDecoder struct {
+	index int
+	dec   decoderFunc
+}
+
+func structDecoder(t reflect.Type) decoderFunc {
+	var (
+		fields  = cachedTypeFields(t)
+		decs    = make(map[string]fieldDecoder, len(fields))
+		unknown = unknownFieldsIndex(t)
+	)
+	for _, f := range fields {
+		decs[f.name] = fieldDecoder{index: f.index, dec: typeDecoder(t.Field(f.index).Type)}
+	}
+
+	return func(dec *Decoder, b []byte, v reflect.Value) (int, error) {
+		if !hasTag(b, Struct) {
+			return dec.decodeValue(b, v)
+		}
+		return dec.decodeStruct(b, v, decs, unknown)
+	}
+}
+
+// hasTag reports whether b starts with a value of typ, with or without a
+// VarLen prefix.
+func hasTag(b []byte, typ Type) bool {
+	switch {
+	case len(b) == 0:
+		return false
+	case Type(b[0]) == VarLen:
+		return len(b) > 1 && Type(b[1]) == typ
+	}
+	return Type(b[0]) == typ
+}
@@ -0,0 +1,106 @@
+package binary
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type planNode struct {
+	Value    int
+	Next     *planNode
+	Children []planNode
+}
+
+type planOrder struct {
+	ID     uint64
+	Name   string
+	Items  []planItem
+	Labels map[string]string
+	Note   interface{}
+}
+
+type planItem struct {
+	SKU   string
+	Price float64
+	Qty   int `binary:"qty,omitempty"`
+}
+
+var testOrder = planOrder{
+	ID:     42,
+	Name:   "order",
+	Items:  []planItem{{"a", 1.5, 2}, {"b", 2.5, 0}},
+	Labels: map[string]string{"x": "1", "y": "2"},
+	Note:   "note",
+}
+
+func TestPlan_Recursive(t *testing.T) {
+	val := planNode{
+		Value:    1,
+		Next:     &planNode{Value: 2, Next: &planNode{Value: 3}},
+		Children: []planNode{{Value: 4}},
+	}
+
+	b, err := Encode(val)
+	assert.NoError(t, err)
+
+	var out planNode
+	n, err := Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, val, out)
+}
+
+func TestPlan_Concurrent(t *testing.T) {
+	type fresh struct {
+		Order planOrder
+		Tags  []string
+	}
+	val := fresh{testOrder, []string{"t"}}
+	want, err := NewEncoderWithOptions(nil, WithCompactInts()).Encode(val)
+	assert.NoError(t, err)
+
+	// the first uses of a type race to compile its plans
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := NewEncoderWithOptions(nil, WithCompactInts()).Encode(val)
+			assert.NoError(t, err)
+			assert.Equal(t, want, b)
+
+			var out fresh
+			_, err = Decode(b, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, val, out)
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkEncode_Struct(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Encode(testOrder); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode_Struct(b *testing.B) {
+	data, err := Encode(testOrder)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out planOrder
+		if _, err := Decode(data, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Skip returns the length in bytes of the encoded value at the start of b,
+// tag included, without decoding it. Values following it in b start at the
+// returned offset. Errors are *DecodeErrors giving the offset and tag of the
+// value that could not be skipped.
+func Skip(b []byte) (int, error) {
+	return decoder.Skip(b)
+}
+
+// Skip is like the package level Skip, reading lengths with the byte order
+// of dec.
+func (dec *Decoder) Skip(b []byte) (int, error) {
+	return dec.begin().skip(b)
+}
+
+// skip returns the length in bytes of the encoded value at the start of b,
+// tag included, without decoding it.
+func (dec *Decoder) skip(b []byte) (int, error) {
+	n, err := dec.skipValue(b)
+	if err != nil {
+		return 0, decodeError(err, b, 0, "", "")
+	}
+	return n, nil
+}
+
+func (dec *Decoder) skipValue(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, ErrBufTooSmall
+	}
+
+	typ := Type(b[0])
+	if typ == VarLen {
+		if len(b) < 2 {
+			return 0, ErrBufTooSmall
+		}
+		if typ = Type(b[1]); !varLen(typ) {
+			return 0, ErrInvalidCodec
+		}
+	}
+	if n := fixedSize(typ); n >= 0 {
+		if len(b) < n+1 {
+			return 0, ErrBufTooSmall
+		}
+		return n + 1, nil
+	}
+
+	switch typ {
+	case ElementValue, ElementRef, Array, ArrayInt, ArrayUint, ArrayFloat, ArrayFloat32,
+		ArrayString, ArrayBool, ArrayInt8, ArrayInt16, ArrayInt32, ArrayInt64, ArrayUint16,
+		ArrayUint32, ArrayUint64, ArrayTimestamp, ArrayDuration, Map, Struct:
+		if err := dec.enter(); err != nil {
+			return 0, err
+		}
+		defer dec.leave()
+	}
+
+	switch typ {
+	case String:
+		p := bytes.IndexByte(b[1:], 0)
+		if p < 0 {
+			return 0, ErrNonStringTailZero
+		}
+		return p + 2, nil
+	case VarString:
+		_, n, err := dec.sized(b)
+		return n, err
+	case ElementValue, ElementRef:
+		n, err := dec.skip(b[1:])
+		if err != nil {
+			return 0, decodeError(err, b, 1, "", "")
+		}
+		return n + 1, nil
+	case Varint, Uvarint:
+		_, n := binary.Uvarint(b[1:])
+		if err := varintErr(n); err != nil {
+			return 0, err
+		}
+		return n + 1, nil
+	case Bytes:
+		l, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		if err := dec.checkLength(l); err != nil {
+			return 0, err
+		}
+		if l > uint64(len(b)-offset) {
+			return 0, ErrBufTooSmall
+		}
+		return offset + int(l), nil
+	case Array, ArrayInt, ArrayUint, ArrayFloat, ArrayFloat32, ArrayString, ArrayBool,
+		ArrayInt8, ArrayInt16, ArrayInt32, ArrayInt64, ArrayUint16, ArrayUint32, ArrayUint64,
+		ArrayTimestamp, ArrayDuration:
+		l, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		for i := uint64(0); i < l; i++ {
+			n, err := dec.skip(b[offset:])
+			if err != nil {
+				return 0, decodeError(err, b, offset, "", "")
+			}
+			offset += n
+		}
+		return offset, nil
+	case Packed:
+		_, elem, l, offset, err := dec.packedHeader(b)
+		if err != nil {
+			return 0, err
+		}
+		return offset + l*fixedSize(elem), nil
+	case BitArray:
+		l, offset, err := dec.bitsHeader(b)
+		if err != nil {
+			return 0, err
+		}
+		return offset + (l+7)/8, nil
+	case Map:
+		l, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		for i := uint64(0); i < l; i++ {
+			if offset >= len(b) {
+				return 0, decodeError(ErrBufTooSmall, b, offset, "map key", "")
+			}
+			if Type(b[offset]) != MapKey {
+				return 0, decodeError(ErrInvalidMapKey, b, offset, "map key", "")
+			}
+			n, err := dec.skip(b[offset+1:])
+			if err != nil {
+				return 0, decodeError(err, b, offset+1, "", "")
+			}
+			offset += n + 1
+			if offset >= len(b) {
+				return 0, decodeError(ErrBufTooSmall, b, offset, "map value", "")
+			}
+			if Type(b[offset]) != MapValue {
+				return 0, decodeError(ErrInvalidMapValue, b, offset, "map value", "")
+			}
+			n, err = dec.skip(b[offset+1:])
+			if err != nil {
+				return 0, decodeError(err, b, offset+1, "", "")
+			}
+			offset += n + 1
+		}
+		return offset, nil
+	case Struct:
+		l, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		for i := uint64(0); i < l; i++ {
+			if offset >= len(b) {
+				return 0, decodeError(ErrBufTooSmall, b, offset, "struct field", "")
+			}
+			_, n, err := dec.fieldName(b[offset:])
+			if err != nil {
+				return 0, decodeError(err, b, offset, "struct field", "")
+			}
+			offset += n
+			if offset >= len(b) {
+				return 0, decodeError(ErrBufTooSmall, b, offset, "struct value", "")
+			}
+			if Type(b[offset]) != StructValue {
+				return 0, decodeError(ErrInvalidStructValue, b, offset, "struct value", "")
+			}
+			n, err = dec.skip(b[offset+1:])
+			if err != nil {
+				return 0, decodeError(err, b, offset+1, "", "")
+			}
+			offset += n + 1
+		}
+		return offset, nil
+	default:
+		return 0, ErrInvalidCodec
+	}
+}
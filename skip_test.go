@@ -0,0 +1,145 @@
+package binary
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type skipRecord struct {
+	ID    uint64
+	Name  string
+	Tags  []string
+	Attrs map[string]interface{}
+	Next  *skipRecord
+	Raw   []byte
+}
+
+var skipValues = []interface{}{
+	nil,
+	uint8(1), uint16(2), uint32(3), uint64(4), uint(5),
+	int8(-1), int16(-2), int32(-3), int64(-4), -5,
+	float32(1.5), 2.5,
+	"", "str",
+	true,
+	time.Second,
+	time.Unix(1600000000, 0),
+	[]byte{}, []byte{1, 2, 3},
+	[]interface{}{1, "a", nil},
+	[]interface{}{nil, nil, nil},
+	[]*int{nil, nil, nil, nil},
+	[]int{1, 2}, []uint{3}, []float64{1}, []float32{2}, []string{"a", "b"}, []bool{true, false, true},
+	[]int8{1}, []int16{2}, []int32{3}, []int64{4}, []uint16{5}, []uint32{6}, []uint64{7},
+	[]time.Time{time.Unix(1, 0)}, []time.Duration{time.Minute},
+	[][]string{{"a"}, {}, nil},
+	map[string]int{"a": 1, "b": 2},
+	map[int][]string{1: {"x"}},
+	skipRecord{
+		ID:    1,
+		Name:  "root",
+		Tags:  []string{"t"},
+		Attrs: map[string]interface{}{"k": []interface{}{1.5, "v"}},
+		Next:  &skipRecord{ID: 2, Raw: []byte{9}},
+	},
+}
+
+// optionSets are the option combinations values are encoded with in tests
+// that cover every format.
+var optionSets = map[string][]Option{
+	"default":   nil,
+	"varstring": {WithVarStrings()},
+	"packed":    {WithPackedArrays(), WithBitPackedBools()},
+	"compact":   {WithCompactInts()},
+	"bigendian": {WithByteOrder(binary.BigEndian)},
+}
+
+func TestSkip(t *testing.T) {
+	for name, opts := range optionSets {
+		t.Run(name, func(t *testing.T) {
+			enc := NewEncoderWithOptions(nil, opts...)
+			dec := NewDecoderWithOptions(nil, opts...)
+			for _, v := range skipValues {
+				b, err := enc.Encode(v)
+				assert.NoError(t, err)
+
+				n, err := dec.Skip(append(b, byte(Int8), 0))
+				assert.NoError(t, err, "%T", v)
+				assert.Equal(t, len(b), n, "%T", v)
+
+				for i := 0; i < len(b); i++ {
+					_, err := dec.Skip(b[:i])
+					assert.Error(t, err, "%T truncated to %d bytes", v, i)
+				}
+			}
+		})
+	}
+}
+
+func TestSkip_Concatenated(t *testing.T) {
+	var (
+		b    []byte
+		ends []int
+		err  error
+	)
+	for _, v := range skipValues {
+		b, err = AppendEncode(b, v)
+		assert.NoError(t, err)
+		ends = append(ends, len(b))
+	}
+
+	var offset int
+	for i := range skipValues {
+		n, err := Skip(b[offset:])
+		assert.NoError(t, err)
+		offset += n
+		assert.Equal(t, ends[i], offset)
+	}
+	assert.Equal(t, len(b), offset)
+}
+
+func TestSkip_Elements(t *testing.T) {
+	b, err := EncodeIndex(0, "value")
+	assert.NoError(t, err)
+	n, err := Skip(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+
+	b, err = EncodeRef(0, "ref")
+	assert.NoError(t, err)
+	n, err = Skip(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+}
+
+func TestSkip_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      []byte
+		err    error
+		offset int
+		typ    Type
+	}{
+		{"empty", nil, ErrBufTooSmall, 0, 0},
+		{"mark", []byte{byte(MapKey), byte(Int8), 1}, ErrInvalidCodec, 0, MapKey},
+		{"varlen scalar", []byte{byte(VarLen), byte(Int8), 1}, ErrInvalidCodec, 0, VarLen},
+		{"map without key", []byte{byte(Map), 1, 0, 0, 0, byte(Int8), 1}, ErrInvalidMapKey, 5, Int8},
+		{"string without zero", []byte{byte(String), 'a'}, ErrNonStringTailZero, 0, String},
+		{"nested", []byte{byte(Array), 2, 0, byte(Int8), 1, byte(String), 'a'}, ErrNonStringTailZero, 5, String},
+		{"map value", []byte{byte(Map), 1, 0, 0, 0, byte(MapKey), byte(Int8), 1, byte(MapValue), byte(Bytes), 9, 0, 0, 0}, ErrBufTooSmall, 9, Bytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Skip(tt.b)
+			var de *DecodeError
+			if assert.True(t, errors.As(err, &de), "got %v", err) {
+				assert.Equal(t, tt.err, de.Err)
+				assert.Equal(t, tt.offset, de.Offset)
+				assert.Equal(t, tt.typ, de.Type)
+			}
+		})
+	}
+}
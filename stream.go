@@ -0,0 +1,324 @@
+package binary
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// fixedSize returns the number of bytes following the tag of a fixed width
+// value, or -1 when typ has a variable length.
+func fixedSize(typ Type) int {
+	switch typ {
+	case Nil:
+		return 0
+	case Uint8, Int8, Bool:
+		return 1
+	case Uint16, Int16:
+		return 2
+	case Uint32, Int32, Float32:
+		return 4
+	case Uint64, Int64, Int, Uint, Float64, Timestamp, Duration:
+		return 8
+	default:
+		return -1
+	}
+}
+
+// readValue appends the next complete value read by dec, tag included, to
+// buf. The length of the value is found from its tag and length prefixes, so
+// only a single value is buffered at a time. It returns io.EOF when the
+// reader ends before the tag.
+func (dec *Decoder) readValue(buf []byte) ([]byte, error) {
+	tag, err := dec.r.ReadByte()
+	if err != nil {
+		return buf, err
+	}
+	return dec.readTagged(append(buf, tag), Type(tag))
+}
+
+// readTagged appends the rest of the value whose tag typ ends buf. Errors are
+// *DecodeErrors giving the offset in buf and the tag of the value that could
+// not be read, and the reader ending early is io.ErrUnexpectedEOF.
+func (dec *Decoder) readTagged(buf []byte, typ Type) ([]byte, error) {
+	start := len(buf) - 1
+	buf, err := dec.readBody(buf, typ)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if _, ok := err.(*DecodeError); err != nil && !ok {
+		err = &DecodeError{Offset: start, Type: typ, Err: err}
+	}
+	return buf, err
+}
+
+func (dec *Decoder) readBody(buf []byte, typ Type) ([]byte, error) {
+	if n := fixedSize(typ); n >= 0 {
+		return readN(dec.r, buf, uint64(n))
+	}
+
+	var err error
+	switch typ {
+	case String:
+		return dec.readString(buf)
+	case Varint, Uvarint:
+		buf, _, err = dec.readUvarint(buf)
+		return buf, err
+	case Bytes:
+		if buf, err = readN(dec.r, buf, 4); err != nil {
+			return buf, err
+		}
+		l := dec.opts.order().Uint32(buf[len(buf)-4:])
+		if err := dec.checkLength(uint64(l)); err != nil {
+			return buf, err
+		}
+		return readN(dec.r, buf, uint64(l))
+	case Array, ArrayInt, ArrayUint, ArrayFloat, ArrayFloat32, ArrayString, ArrayBool,
+		ArrayInt8, ArrayInt16, ArrayInt32, ArrayInt64, ArrayUint16, ArrayUint32, ArrayUint64,
+		ArrayTimestamp, ArrayDuration:
+		if buf, err = readN(dec.r, buf, 2); err != nil {
+			return buf, err
+		}
+		l := dec.opts.order().Uint16(buf[len(buf)-2:])
+		return dec.readElems(buf, uint64(l))
+	case VarLen:
+		tag, err := dec.r.ReadByte()
+		if err != nil {
+			return buf, err
+		}
+		if !varLen(Type(tag)) {
+			return buf, ErrInvalidCodec
+		}
+		buf, l, err := dec.readUvarint(append(buf, tag))
+		if err != nil {
+			return buf, err
+		}
+		if l > maxArrayLen {
+			return buf, ErrCorrupt
+		}
+		switch Type(tag) {
+		case Bytes:
+			if err := dec.checkLength(l); err != nil {
+				return buf, err
+			}
+			return readN(dec.r, buf, l)
+		case Map:
+			return dec.readEntries(buf, l)
+		case Struct:
+			return dec.readFields(buf, l)
+		}
+		return dec.readElems(buf, l)
+	case VarString:
+		return dec.readSized(buf)
+	case Packed:
+		tag, err := dec.r.ReadByte()
+		if err != nil {
+			return buf, err
+		}
+		elem, ok := arrayElems[Type(tag)]
+		if !ok {
+			return buf, ErrInvalidCodec
+		}
+		buf, l, err := dec.readUvarint(append(buf, tag))
+		if err != nil {
+			return buf, err
+		}
+		if l > maxArrayLen {
+			return buf, ErrCorrupt
+		}
+		if err := dec.checkElements(l); err != nil {
+			return buf, err
+		}
+		return readN(dec.r, buf, l*uint64(fixedSize(elem)))
+	case BitArray:
+		buf, l, err := dec.readUvarint(buf)
+		if err != nil {
+			return buf, err
+		}
+		if l > maxArrayLen {
+			return buf, ErrCorrupt
+		}
+		if err := dec.checkElements(l); err != nil {
+			return buf, err
+		}
+		return readN(dec.r, buf, (l+7)/8)
+	case Map:
+		if buf, err = readN(dec.r, buf, 4); err != nil {
+			return buf, err
+		}
+		l := dec.opts.order().Uint32(buf[len(buf)-4:])
+		return dec.readEntries(buf, uint64(l))
+	case Struct:
+		if buf, err = readN(dec.r, buf, 4); err != nil {
+			return buf, err
+		}
+		l := dec.opts.order().Uint32(buf[len(buf)-4:])
+		return dec.readFields(buf, uint64(l))
+	default:
+		return buf, ErrInvalidCodec
+	}
+}
+
+// readElems appends n array elements to buf.
+func (dec *Decoder) readElems(buf []byte, n uint64) ([]byte, error) {
+	err := dec.readContainer(n)
+	if err != nil {
+		return buf, err
+	}
+	defer dec.leave()
+
+	for i := uint64(0); i < n; i++ {
+		if buf, err = dec.readElem(buf); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// readEntries appends n map entries, marks included, to buf.
+func (dec *Decoder) readEntries(buf []byte, n uint64) ([]byte, error) {
+	err := dec.readContainer(n)
+	if err != nil {
+		return buf, err
+	}
+	defer dec.leave()
+
+	for i := uint64(0); i < n; i++ {
+		if buf, err = readMark(dec.r, buf, MapKey, "map key", ErrInvalidMapKey); err != nil {
+			return buf, err
+		}
+		if buf, err = dec.readElem(buf); err != nil {
+			return buf, err
+		}
+		if buf, err = readMark(dec.r, buf, MapValue, "map value", ErrInvalidMapValue); err != nil {
+			return buf, err
+		}
+		if buf, err = dec.readElem(buf); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// readFields appends n struct fields, names and marks included, to buf.
+func (dec *Decoder) readFields(buf []byte, n uint64) ([]byte, error) {
+	if err := dec.readContainer(n); err != nil {
+		return buf, err
+	}
+	defer dec.leave()
+
+	for i := uint64(0); i < n; i++ {
+		tag, err := dec.r.ReadByte()
+		if err != nil {
+			return buf, err
+		}
+		switch buf = append(buf, tag); Type(tag) {
+		case StructField:
+			buf, err = dec.readString(buf)
+		case VarStructField:
+			buf, err = dec.readSized(buf)
+		default:
+			err = &DecodeError{Offset: len(buf) - 1, Type: Type(tag), Expected: "struct field", Err: ErrInvalidStructField}
+		}
+		if err != nil {
+			return buf, err
+		}
+		if buf, err = readMark(dec.r, buf, StructValue, "struct value", ErrInvalidStructValue); err != nil {
+			return buf, err
+		}
+		if buf, err = dec.readElem(buf); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// readContainer checks the count n of an array, map or struct about to be
+// read against the limits of dec and enters it. It must be followed by a
+// leave when it succeeds.
+func (dec *Decoder) readContainer(n uint64) error {
+	if err := dec.checkElements(n); err != nil {
+		return err
+	}
+	return dec.enter()
+}
+
+func (dec *Decoder) readElem(buf []byte) ([]byte, error) {
+	tag, err := dec.r.ReadByte()
+	if err != nil {
+		return buf, err
+	}
+	return dec.readTagged(append(buf, tag), Type(tag))
+}
+
+// readUvarint appends the next uvarint to buf and returns its value.
+func (dec *Decoder) readUvarint(buf []byte) ([]byte, uint64, error) {
+	l, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			err = ErrCorrupt
+		}
+		return buf, 0, err
+	}
+
+	var lb [binary.MaxVarintLen64]byte
+	return append(buf, lb[:binary.PutUvarint(lb[:], l)]...), l, nil
+}
+
+// readSized appends the next uvarint length prefix and the payload it
+// announces to buf.
+func (dec *Decoder) readSized(buf []byte) ([]byte, error) {
+	buf, l, err := dec.readUvarint(buf)
+	if err != nil {
+		return buf, err
+	}
+	if err := dec.checkLength(l); err != nil {
+		return buf, err
+	}
+	return readN(dec.r, buf, l)
+}
+
+// readMark appends the mark typ to buf, failing with invalid at the offset
+// of any other tag.
+func readMark(r *bufio.Reader, buf []byte, typ Type, expected string, invalid error) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return buf, err
+	}
+	if Type(tag) != typ {
+		return buf, &DecodeError{Offset: len(buf), Type: Type(tag), Expected: expected, Err: invalid}
+	}
+	return append(buf, tag), nil
+}
+
+// readString appends the next zero terminated string to buf, failing once
+// it grows longer than the maximum length.
+func (dec *Decoder) readString(buf []byte) ([]byte, error) {
+	start := len(buf)
+	for {
+		s, err := dec.r.ReadSlice(0)
+		buf = append(buf, s...)
+		if err != bufio.ErrBufferFull {
+			return buf, err
+		}
+		if err := dec.checkLength(uint64(len(buf) - start)); err != nil {
+			return buf, err
+		}
+	}
+}
+
+func readN(r *bufio.Reader, buf []byte, n uint64) ([]byte, error) {
+	for n > 0 {
+		chunk := n
+		if chunk > uint64(r.Size()) {
+			chunk = uint64(r.Size())
+		}
+		off := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[off:]); err != nil {
+			return buf, err
+		}
+		n -= chunk
+	}
+	return buf, nil
+}
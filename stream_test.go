@@ -0,0 +1,116 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	type point struct {
+		X, Y int
+		Tags []string
+	}
+
+	values := []interface{}{
+		uint8(1),
+		"hello",
+		[]byte("world"),
+		time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		[]int{1, 2, 3},
+		map[string]int{"a": 1, "b": 2},
+		point{X: 1, Y: 2, Tags: []string{"p"}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range values {
+		_, err := enc.Encode(v)
+		assert.NoError(t, err)
+	}
+
+	dec := NewDecoder(&buf)
+	var (
+		u8  uint8
+		s   string
+		bs  []byte
+		ts  time.Time
+		is  []int
+		m   map[string]int
+		pt  point
+		out = []interface{}{&u8, &s, &bs, &ts, &is, &m, &pt}
+	)
+	for _, v := range out {
+		assert.NoError(t, dec.DecodeNext(v))
+	}
+	assert.Equal(t, values, []interface{}{u8, s, bs, ts, is, m, pt})
+	assert.Equal(t, io.EOF, dec.DecodeNext(new(interface{})))
+}
+
+func TestStream_Truncated(t *testing.T) {
+	b, err := Encode(map[string]string{"key": "value"})
+	assert.NoError(t, err)
+
+	dec := NewDecoder(bytes.NewReader(b[:len(b)-3]))
+	err = dec.DecodeNext(new(interface{}))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "got %v", err)
+}
+
+func TestStream_Errors(t *testing.T) {
+	valid, err := Encode("a")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		b      []byte
+		err    error
+		offset int
+		typ    Type
+	}{
+		{"truncated", nil, []byte{byte(Array), 2, 0, byte(Int8), 1, byte(Int16), 1}, io.ErrUnexpectedEOF, 5, Int16},
+		{"invalid codec", nil, []byte{byte(Array), 1, 0, byte(MapKey)}, ErrInvalidCodec, 3, MapKey},
+		{"map without key", nil, []byte{byte(Map), 1, 0, 0, 0, byte(Int8), 1}, ErrInvalidMapKey, 5, Int8},
+		{"struct without field", nil, []byte{byte(Struct), 1, 0, 0, 0, byte(Int8), 1}, ErrInvalidStructField, 5, Int8},
+		{"bad varint", nil, []byte{byte(Array), 1, 0, byte(Varint), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, ErrCorrupt, 3, Varint},
+		{"depth", []Option{WithMaxDepth(1)}, []byte{byte(Array), 1, 0, byte(Array), 0, 0}, ErrMaxDepth, 3, Array},
+		{"length", []Option{WithMaxLength(2)}, []byte{byte(Array), 1, 0, byte(Bytes), 3, 0, 0, 0, 1, 2, 3}, ErrMaxLength, 3, Bytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoderWithOptions(bytes.NewReader(append(valid, tt.b...)), tt.opts...)
+			var s string
+			assert.NoError(t, dec.DecodeNext(&s))
+
+			err := dec.DecodeNext(new(interface{}))
+			var de *DecodeError
+			if assert.True(t, errors.As(err, &de), "got %v", err) {
+				assert.Equal(t, tt.err, de.Err)
+				assert.Equal(t, tt.offset, de.Offset)
+				assert.Equal(t, tt.typ, de.Type)
+			}
+		})
+	}
+}
+
+func TestStream_ByteOrder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoderWithOptions(&buf, WithByteOrder(binary.BigEndian))
+	b, err := enc.Encode(map[string]uint32{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(Map), 0, 0, 0, 1,
+		byte(MapKey), byte(String), 'a', 0,
+		byte(MapValue), byte(Uint32), 0, 0, 0, 1,
+	}, b)
+
+	var m map[string]uint32
+	dec := NewDecoderWithOptions(&buf, WithByteOrder(binary.BigEndian))
+	assert.NoError(t, dec.DecodeNext(&m))
+	assert.Equal(t, map[string]uint32{"a": 1}, m)
+}
@@ -41,16 +41,33 @@ func _() {
 	_ = x[MapValue-225]
 	_ = x[ElementValue-224]
 	_ = x[ElementRef-223]
+	_ = x[VarLen-222]
+	_ = x[VarString-221]
+	_ = x[VarStructField-220]
+	_ = x[Nil-219]
+	_ = x[ArrayInt8-218]
+	_ = x[ArrayInt16-217]
+	_ = x[ArrayInt32-216]
+	_ = x[ArrayInt64-215]
+	_ = x[ArrayUint16-214]
+	_ = x[ArrayUint32-213]
+	_ = x[ArrayUint64-212]
+	_ = x[ArrayTimestamp-211]
+	_ = x[ArrayDuration-210]
+	_ = x[Packed-209]
+	_ = x[BitArray-208]
+	_ = x[Varint-207]
+	_ = x[Uvarint-206]
 }
 
-const _Type_name = "ElementRefElementValueMapValueMapKeyMapStructValueStructFieldStructArrayBoolArrayStringArrayFloat32ArrayFloatArrayUintArrayIntArrayBytesTimestampRuneDurationBoolStringFloat64Float32UintIntInt64Int32Int16Int8Uint64Uint32Uint16Uint8"
+const _Type_name = "UvarintVarintBitArrayPackedArrayDurationArrayTimestampArrayUint64ArrayUint32ArrayUint16ArrayInt64ArrayInt32ArrayInt16ArrayInt8NilVarStructFieldVarStringVarLenElementRefElementValueMapValueMapKeyMapStructValueStructFieldStructArrayBoolArrayStringArrayFloat32ArrayFloatArrayUintArrayIntArrayBytesTimestampRuneDurationBoolStringFloat64Float32UintIntInt64Int32Int16Int8Uint64Uint32Uint16Uint8"
 
-var _Type_index = [...]uint8{0, 10, 22, 30, 36, 39, 50, 61, 67, 76, 87, 99, 109, 118, 126, 131, 136, 145, 149, 157, 161, 167, 174, 181, 185, 188, 193, 198, 203, 207, 213, 219, 225, 230}
+var _Type_index = [...]uint16{0, 7, 13, 21, 27, 40, 54, 65, 76, 87, 97, 107, 117, 126, 129, 143, 152, 158, 168, 180, 188, 194, 197, 208, 219, 225, 234, 245, 257, 267, 276, 284, 289, 294, 303, 307, 315, 319, 325, 332, 339, 343, 346, 351, 356, 361, 365, 371, 377, 383, 388}
 
 func (i Type) String() string {
-	i -= 223
-	if i >= Type(len(_Type_index)-1) {
-		return "Type(" + strconv.FormatInt(int64(i+223), 10) + ")"
+	idx := int(i) - 206
+	if i < 206 || idx >= len(_Type_index)-1 {
+		return "Type(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _Type_name[_Type_index[i]:_Type_index[i+1]]
+	return _Type_name[_Type_index[idx]:_Type_index[idx+1]]
 }
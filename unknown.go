@@ -0,0 +1,197 @@
+package binary
+
+import (
+	"encoding/binary"
+	"reflect"
+)
+
+// UnknownFields holds the encoded fields of a Struct that the struct it was
+// decoded into has no field for. When a struct has an exported field of this
+// type, Decode fills it with the raw name and value of each such field in the
+// order they appear, even WithDisallowUnknownFields, and Encode writes them
+// back after the known fields. Programs built against an older version of a
+// struct thereby pass newer fields through unchanged. Fields of this type are
+// never encoded under their own name.
+//
+// The fields are held in little-endian byte order, whatever the byte order
+// of the Decoder that collected them, and are written in the byte order of
+// the Encoder, so they may be passed between streams of different orders.
+type UnknownFields []byte
+
+var unknownFieldsType = reflect.TypeOf(UnknownFields(nil))
+
+// unknownDecoder reads UnknownFields.
+var unknownDecoder = &Decoder{opts: options{byteOrder: binary.LittleEndian}}
+
+// littleEndian reports whether o reads and writes little-endian values, so
+// UnknownFields need no conversion.
+func (o *options) littleEndian() bool {
+	return o.order().Uint16([]byte{1, 0}) == 1
+}
+
+// unknownFieldsIndex returns the index of the first exported UnknownFields
+// field of the struct type t, or -1 if it has none.
+func unknownFieldsIndex(t reflect.Type) int {
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.PkgPath == "" && sf.Type == unknownFieldsType {
+			return i
+		}
+	}
+	return -1
+}
+
+// appendUnknownField appends the struct field f, name and mark included, to
+// u, converting it from the byte order of dec.
+func (dec *Decoder) appendUnknownField(u UnknownFields, f []byte) (UnknownFields, error) {
+	start := len(u)
+	u = append(u, f...)
+	if dec.opts.littleEndian() {
+		return u, nil
+	}
+	if _, err := dec.begin().swapField(u[start:]); err != nil {
+		return u[:start], err
+	}
+	return u, nil
+}
+
+// appendUnknownFields appends the fields u to b in the byte order of enc.
+func (enc *Encoder) appendUnknownFields(b []byte, u UnknownFields) ([]byte, error) {
+	start := len(b)
+	b = append(b, u...)
+	if enc.opts.littleEndian() {
+		return b, nil
+	}
+	dec := unknownDecoder.begin()
+	for offset := start; offset < len(b); {
+		n, err := dec.swapField(b[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+	}
+	return b, nil
+}
+
+// swapField reverses in place the multi-byte integers and floats of the
+// struct field at the start of b, read in the byte order of dec, and returns
+// its length.
+func (dec *Decoder) swapField(b []byte) (int, error) {
+	_, offset, err := dec.DecodeFieldName(b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dec.swapValue(b[offset:])
+	if err != nil {
+		return 0, err
+	}
+	return offset + n, nil
+}
+
+// swapValue reverses in place the multi-byte integers and floats of the
+// value at the start of b, tag and length prefixes included, converting it
+// from the byte order of dec to the other one. It returns the length of the
+// value.
+func (dec *Decoder) swapValue(b []byte) (int, error) {
+	n, err := dec.skip(b)
+	if err != nil {
+		return 0, err
+	}
+
+	typ := Type(b[0])
+	if typ == VarLen {
+		typ = Type(b[1])
+	}
+	if size := fixedSize(typ); size > 1 {
+		reverse(b[1:n])
+		return n, nil
+	}
+
+	switch typ {
+	case ElementValue, ElementRef:
+		_, err = dec.swapValue(b[1:])
+		return n, err
+	case Packed:
+		_, elem, _, offset, err := dec.packedHeader(b)
+		if err != nil {
+			return 0, err
+		}
+		if size := fixedSize(elem); size > 1 {
+			for ; offset < n; offset += size {
+				reverse(b[offset : offset+size])
+			}
+		}
+		return n, nil
+	case Bytes:
+		if Type(b[0]) != VarLen {
+			reverse(b[1:5])
+		}
+		return n, nil
+	case Array, ArrayInt, ArrayUint, ArrayFloat, ArrayFloat32, ArrayString, ArrayBool,
+		ArrayInt8, ArrayInt16, ArrayInt32, ArrayInt64, ArrayUint16, ArrayUint32, ArrayUint64,
+		ArrayTimestamp, ArrayDuration:
+		_, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		if Type(b[0]) != VarLen {
+			reverse(b[1:3])
+		}
+		for offset < n {
+			m, err := dec.swapValue(b[offset:])
+			if err != nil {
+				return 0, err
+			}
+			offset += m
+		}
+		return n, nil
+	case Map, Struct:
+		_, offset, err := dec.header(b)
+		if err != nil {
+			return 0, err
+		}
+		if Type(b[0]) != VarLen {
+			reverse(b[1:5])
+		}
+		for offset < n {
+			var m int
+			if typ == Struct {
+				m, err = dec.swapField(b[offset:])
+			} else if m, err = dec.swapValue(b[offset+1:]); err == nil {
+				var v int
+				v, err = dec.swapValue(b[offset+m+2:])
+				m += v + 2
+			}
+			if err != nil {
+				return 0, err
+			}
+			offset += m
+		}
+		return n, nil
+	}
+	return n, nil
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// countFields returns the number of struct fields encoded in u.
+func (dec *Decoder) countFields(u []byte) (int, error) {
+	dec = dec.begin()
+	var count int
+	for offset := 0; offset < len(u); count++ {
+		_, n, err := dec.DecodeFieldName(u[offset:])
+		if err != nil {
+			return 0, err
+		}
+		offset += n
+
+		if n, err = dec.skip(u[offset:]); err != nil {
+			return 0, err
+		}
+		offset += n
+	}
+	return count, nil
+}
@@ -0,0 +1,175 @@
+package binary
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownFields(t *testing.T) {
+	type v1 struct {
+		ID    int
+		Name  string
+		Extra UnknownFields
+	}
+
+	type v2 struct {
+		ID    int
+		Tags  []string
+		Name  string
+		Attrs map[string]interface{}
+		Child *v2
+	}
+
+	in := v2{
+		ID:    7,
+		Tags:  []string{"a", "b"},
+		Name:  "new",
+		Attrs: map[string]interface{}{"k": "v"},
+		Child: &v2{ID: 8},
+	}
+
+	for name, opts := range optionSets {
+		t.Run(name, func(t *testing.T) {
+			enc := NewEncoderWithOptions(nil, opts...)
+			dec := NewDecoderWithOptions(nil, append(opts, WithDisallowUnknownFields())...)
+
+			b, err := enc.Encode(in)
+			assert.NoError(t, err)
+
+			var relay v1
+			n, err := dec.Decode(b, &relay)
+			assert.NoError(t, err)
+			assert.Equal(t, len(b), n)
+			assert.Equal(t, 7, relay.ID)
+			assert.Equal(t, "new", relay.Name)
+			assert.NotEmpty(t, relay.Extra)
+
+			relay.Name = "changed"
+			out, err := enc.Encode(relay)
+			assert.NoError(t, err)
+
+			var got v2
+			_, err = dec.Decode(out, &got)
+			assert.NoError(t, err)
+			want := in
+			want.Name = "changed"
+			assert.Equal(t, want, got)
+
+			// the unknown fields of a previous decode are replaced
+			_, err = dec.Decode(out, &relay)
+			assert.NoError(t, err)
+			again, err := enc.Encode(relay)
+			assert.NoError(t, err)
+			assert.Equal(t, out, again)
+		})
+	}
+}
+
+func TestUnknownFields_Empty(t *testing.T) {
+	type record struct {
+		ID    int
+		Extra UnknownFields `binary:"extra"`
+	}
+
+	b, err := Encode(record{ID: 1})
+	assert.NoError(t, err)
+	want, err := Encode(struct{ ID int }{1})
+	assert.NoError(t, err)
+	assert.Equal(t, want, b)
+
+	out := record{Extra: UnknownFields{1}}
+	_, err = Decode(b, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, record{ID: 1}, out)
+}
+
+func TestUnknownFields_Invalid(t *testing.T) {
+	type record struct {
+		ID    int
+		Extra UnknownFields
+	}
+
+	_, err := Encode(record{Extra: UnknownFields{byte(Int8), 1}})
+	assert.True(t, errors.Is(err, ErrInvalidStructField), "got %v", err)
+
+	_, err = Encode(record{Extra: UnknownFields{byte(StructField), 'a', 0, byte(StructValue)}})
+	assert.True(t, errors.Is(err, ErrBufTooSmall), "got %v", err)
+}
+
+func TestUnknownFields_ByteOrder(t *testing.T) {
+	type v1 struct {
+		Name  string
+		Extra UnknownFields
+	}
+
+	type v2 struct {
+		Name   string
+		Small  int16
+		Ratio  float32
+		Score  float64
+		When   time.Time
+		Wait   time.Duration
+		Data   []byte
+		Counts []uint32
+		Flags  []bool
+		Attrs  map[string]int64
+		Items  []interface{}
+		Child  *v2
+	}
+
+	in := v2{
+		Name:   "new",
+		Small:  -300,
+		Ratio:  1.5,
+		Score:  -2.25,
+		When:   time.Unix(1600000000, 5).UTC(),
+		Wait:   time.Minute,
+		Data:   []byte{1, 2, 3},
+		Counts: []uint32{1, 1 << 20},
+		Flags:  []bool{true, false, true},
+		Attrs:  map[string]int64{"a": 1 << 40, "b": -1},
+		Items:  []interface{}{uint16(513), "x", []int32{-7}},
+		Child:  &v2{Small: 258, Counts: []uint32{}},
+	}
+	sets := map[string][]Option{
+		"little":      nil,
+		"big":         {WithByteOrder(binary.BigEndian)},
+		"big packed":  {WithByteOrder(binary.BigEndian), WithPackedArrays(), WithBitPackedBools()},
+		"big compact": {WithByteOrder(binary.BigEndian), WithCompactInts(), WithVarStrings()},
+	}
+
+	want, err := Encode(in)
+	assert.NoError(t, err)
+	var little v1
+	_, err = Decode(want, &little)
+	assert.NoError(t, err)
+
+	for from, fromOpts := range sets {
+		for to, toOpts := range sets {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				b, err := NewEncoderWithOptions(nil, fromOpts...).Encode(in)
+				assert.NoError(t, err)
+
+				var relay v1
+				_, err = NewDecoderWithOptions(nil, fromOpts...).Decode(b, &relay)
+				assert.NoError(t, err)
+				if from == "big" {
+					// the fields are held in the same order whatever the decoder
+					assert.Equal(t, little.Extra, relay.Extra)
+				}
+
+				out, err := NewEncoderWithOptions(nil, toOpts...).Encode(relay)
+				assert.NoError(t, err)
+
+				var got v2
+				_, err = NewDecoderWithOptions(nil, toOpts...).Decode(out, &got)
+				assert.NoError(t, err)
+				assert.Equal(t, in, got)
+			})
+		}
+	}
+}